@@ -0,0 +1,56 @@
+package capabilities
+
+import (
+	"strings"
+)
+
+type Capabilities struct {
+	ContextWindow int // max prompt + output tokens
+}
+
+// known maps model id prefixes to their capabilities. The longest matching prefix wins,
+// so that e.g. gpt-4o doesn't match gpt-4.
+var known = map[string]Capabilities{
+	"gpt-3.5-turbo":    {ContextWindow: 16_385},
+	"gpt-4":            {ContextWindow: 8_192},
+	"gpt-4-turbo":      {ContextWindow: 128_000},
+	"gpt-4o":           {ContextWindow: 128_000},
+	"gpt-4.1":          {ContextWindow: 1_047_576},
+	"gpt-4.5":          {ContextWindow: 128_000},
+	"gpt-5":            {ContextWindow: 400_000},
+	"o1":               {ContextWindow: 200_000},
+	"o3":               {ContextWindow: 200_000},
+	"o4-mini":          {ContextWindow: 200_000},
+	"claude":           {ContextWindow: 200_000},
+	"gemini-1.5-flash": {ContextWindow: 1_048_576},
+	"gemini-1.5-pro":   {ContextWindow: 2_097_152},
+	"gemini-2.0":       {ContextWindow: 1_048_576},
+	"gemini-2.5":       {ContextWindow: 1_048_576},
+	"llama3":           {ContextWindow: 8_192},
+	"llama3.1":         {ContextWindow: 131_072},
+	"llama3.2":         {ContextWindow: 131_072},
+	"llama3.3":         {ContextWindow: 131_072},
+	"mistral":          {ContextWindow: 32_768},
+	"qwen2.5":          {ContextWindow: 32_768},
+	"gemma2":           {ContextWindow: 8_192},
+	"gemma3":           {ContextWindow: 131_072},
+	"deepseek-r1":      {ContextWindow: 131_072},
+}
+
+// Lookup finds the capabilities of a model by its id. Provider specific
+// decorations, like vertex "@version" suffixes, don't affect the lookup.
+func Lookup(model string) (Capabilities, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	model = strings.TrimPrefix(model, "models/")
+
+	best := ""
+	for prefix := range known {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Capabilities{}, false
+	}
+	return known[best], true
+}
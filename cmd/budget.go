@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/ledger"
+	"github.com/gigurra/ai/pricing"
+	"github.com/gigurra/ai/session"
+	"log/slog"
+	"time"
+)
+
+const budgetWarningRatio = 0.8
+
+// checkBudgets refuses requests that would exceed a configured budget, unless forced,
+// and warns when a budget is nearly used up. Spending comes from the usage ledger, and
+// the request itself is counted with its estimated input cost.
+func checkBudgets(cfg config.Config, state session.State, estimate requestEstimate, force bool) {
+	if maxTokens := cfg.Budget.SessionTokens; maxTokens > 0 {
+		used := state.InputTokensAccum + state.OutputTokensAccum
+		checkLimit(fmt.Sprintf("session token cap of %d", maxTokens), float64(used), float64(estimate.Tokens), float64(maxTokens),
+			func(v float64) string { return fmt.Sprintf("%d tokens", int(v)) }, force)
+	}
+
+	providerLimits := config.BudgetLimits{}
+	for name, limits := range cfg.Budget.Providers {
+		if normalizeProviderName(name) == estimate.Provider {
+			providerLimits = limits
+		}
+	}
+	if cfg.Budget.Daily <= 0 && cfg.Budget.Monthly <= 0 && providerLimits.Daily <= 0 && providerLimits.Monthly <= 0 {
+		return
+	}
+
+	now := time.Now()
+	entries, err := ledger.Read(ledger.StartOfMonth(now))
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to read the usage ledger to check budgets: %v", err))
+	}
+
+	checks := []struct {
+		name     string
+		limit    float64
+		since    time.Time
+		provider string
+	}{
+		{"daily budget", cfg.Budget.Daily, ledger.StartOfDay(now), ""},
+		{"monthly budget", cfg.Budget.Monthly, ledger.StartOfMonth(now), ""},
+		{"daily " + estimate.Provider + " budget", providerLimits.Daily, ledger.StartOfDay(now), estimate.Provider},
+		{"monthly " + estimate.Provider + " budget", providerLimits.Monthly, ledger.StartOfMonth(now), estimate.Provider},
+	}
+	for _, check := range checks {
+		if check.limit <= 0 {
+			continue
+		}
+		spent := ledger.Spent(entries, check.since, check.provider)
+		checkLimit(fmt.Sprintf("%s of %s", check.name, pricing.FormatUSD(check.limit)), spent, estimate.InputCost, check.limit, pricing.FormatUSD, force)
+	}
+}
+
+func checkLimit(name string, used float64, request float64, limit float64, format func(float64) string, force bool) {
+	if used >= limit || used+request > limit {
+		if force {
+			slog.Warn(fmt.Sprintf("Exceeding the %s (%s used), sending anyway because of --force", name, format(used)))
+			return
+		}
+		common.FailAndExit(1, fmt.Sprintf("This request would exceed the %s (%s used, this request ~%s). Pass --force to send it anyway.", name, format(used), format(request)))
+	}
+	if used+request >= limit*budgetWarningRatio {
+		slog.Warn(fmt.Sprintf("%.0f%% of the %s is used (%s)", 100*(used+request)/limit, name, format(used+request)))
+	}
+}
@@ -2,8 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/ledger"
+	"github.com/gigurra/ai/providers"
 	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/wirelog"
 	"github.com/google/uuid"
 	"github.com/samber/lo"
 	"strings"
@@ -32,3 +38,60 @@ func isUUID(s string) bool {
 	_, err := uuid.Parse(s)
 	return err == nil
 }
+
+// configuredEmbedder returns the provider to use for embeddings: the --provider override,
+// or else embedding_provider from the config, or else the current provider. Calls are wire
+// logged in the dir of sessionID, if wire logging is on in the config or by wireLog.
+// Returns an error if that provider is not configured, can't be created or doesn't support embeddings.
+func configuredEmbedder(providerOverride boa.Optional[string], wireLog bool, sessionID string) (domain.Embedder, error) {
+	cfgFilePath, storedCfg := config.LoadCfgFile()
+	if storedCfg.EmbeddingProvider != "" {
+		storedCfg.Provider = storedCfg.EmbeddingProvider
+	}
+	cfg, err := config.CheckCfg(cfgFilePath, storedCfg, &config.CliParams{Provider: providerOverride})
+	if err != nil {
+		return nil, err
+	}
+	cfg.WireLog = cfg.WireLog || wireLog
+	return createEmbedder(cfg, sessionID)
+}
+
+// createProvider creates the configured provider, with its calls recorded in the usage ledger
+func createProvider(cfg config.Config, sessionID string) domain.Provider {
+	if cfg.WireLog {
+		wirelog.Enable(wireLogDir(sessionID))
+	}
+	providerName := normalizeProviderName(cfg.Provider)
+	return ledger.Recording(providers.CreateProvider(cfg), ledger.Entry{
+		Provider: providerName,
+		Model:    cfg.Model(providerName),
+		Session:  sessionID,
+	}, cfg.Pricing.Prices)
+}
+
+// requireFSStorage fails unless sessions are stored in the session dirs, which git syncs
+func requireFSStorage() {
+	if backend := session.ActiveStore().Name(); backend != session.BackendFS {
+		common.FailAndExit(1, fmt.Sprintf("Sessions are stored in %s, git sync needs them in the session dir (ai storage migrate --to fs)", backend))
+	}
+}
+
+// wireLogDir is in the session dir, or the app dir for calls outside of a session
+func wireLogDir(sessionID string) string {
+	if sessionID == "" {
+		return common.AppDir() + "/wire"
+	}
+	return session.Dir() + "/" + sessionID + "/wire"
+}
+
+// createEmbedder is like createProvider, for embeddings
+func createEmbedder(cfg config.Config, sessionID string) (domain.Embedder, error) {
+	if cfg.WireLog {
+		wirelog.Enable(wireLogDir(sessionID))
+	}
+	embedder, err := providers.CreateEmbedder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.RecordingEmbedder(embedder, ledger.Entry{Provider: normalizeProviderName(cfg.Provider), Session: sessionID}, cfg.Pricing.Prices), nil
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/ctxwindow"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/tokens"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"time"
+)
+
+type CompactParams struct {
+	config.CliSubcParams
+	Keep    int  `descr:"Number of recent messages to keep as they are" default:"2" name:"keep"`
+	Restore bool `descr:"Undo the last compaction, restoring the archived history" default:"false" name:"restore"`
+}
+
+func Compact() *cobra.Command {
+	return boa.CmdT[CompactParams]{
+		Use:         "compact",
+		Short:       "Replace older turns of the session with a summary (the original is archived)",
+		ParamEnrich: config.CliParamEnricher,
+		RunFunc: func(p *CompactParams, cmd *cobra.Command, args []string) {
+			if p.Verbose.Value() {
+				slog.SetLogLoggerLevel(slog.LevelDebug)
+			}
+
+			sessionID := session.GetSessionID(p.Session.GetOrElse(""))
+			if !session.StoredSessionExists(sessionID) {
+				common.FailAndExit(1, fmt.Sprintf("Session empty or not found: %s", sessionID))
+			}
+
+			if p.Restore {
+				archives := session.ListArchives(sessionID)
+				if len(archives) == 0 {
+					common.FailAndExit(1, fmt.Sprintf("No archived history to restore for session %s", sessionID))
+				}
+				state := session.RestoreArchive(sessionID, archives[len(archives)-1])
+				fmt.Printf("Restored %d messages from archive %d\n", len(state.History), archives[len(archives)-1])
+				return
+			}
+
+			state := session.LoadSession(sessionID)
+			if p.Keep < 0 || len(state.History) <= p.Keep {
+				fmt.Printf("Nothing to compact, the session has %d messages\n", len(state.History))
+				return
+			}
+
+			cfgFilePath, storedCfg := config.LoadCfgFile()
+			cfg := config.ValidateCfg(cfgFilePath, storedCfg, p.ToCliParams())
+			provider := createProvider(cfg, sessionID)
+
+			split := len(state.History) - p.Keep
+			var older []domain.Message
+			for _, entry := range state.History[:split] {
+				if entry.Type == "message" {
+					older = append(older, entry.Message)
+				}
+			}
+
+			summary, err := summarizeMessages(provider, older)
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to summarize the session: %v", err))
+			}
+
+			tokensBefore := tokens.EstimateMessages(state.MessageHistory())
+			archive := session.ArchiveState(sessionID)
+
+			compacted := []session.HistoryEntry{session.NewEntry(ctxwindow.SummaryNote(summary))}
+			state.History = append(compacted, state.History[split:]...)
+			tokensAfter := tokens.EstimateMessages(state.MessageHistory())
+
+			state.Compaction = &session.Compaction{
+				At:           time.Now(),
+				Archive:      archive,
+				TokensBefore: tokensBefore,
+				TokensAfter:  tokensAfter,
+			}
+			session.StoreSession(state)
+
+			fmt.Printf("Compacted %d messages into a summary: ~%d -> ~%d tokens. The original history is kept as archive %d, restore it with ai compact --restore\n",
+				split, tokensBefore, tokensAfter, archive)
+		},
+	}.ToCobra()
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/capabilities"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/ctxwindow"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/util"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const defaultReserveOutputTokens = 4096
+
+const summarizeInstruction = "Summarize the conversation above for your own future reference. " +
+	"Keep facts, decisions, names, code identifiers, commands and open questions. Be concise, and don't add anything new."
+
+func ContextStrategy() *cobra.Command {
+	var p struct {
+		Strategy boa.Optional[string] `descr:"Context strategy for the current session, or 'default' to use the configured one" positional:"true"`
+	}
+	return boa.Cmd{
+		Use:    "context-strategy",
+		Short:  "Show or set how the current session is fit into the model context window",
+		Params: &p,
+		ValidArgsFunc: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return append(lo.Map(ctxwindow.Strategies, func(s ctxwindow.Strategy, _ int) string { return string(s) }), "default"), cobra.ShellCompDirectiveDefault
+		},
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			state := session.LoadSession(session.GetSessionID(""))
+
+			if !p.Strategy.HasValue() {
+				_, storedCfg := config.LoadCfgFile()
+				if state.ContextStrategy != "" {
+					fmt.Printf("%s (session)\n", state.ContextStrategy)
+				} else {
+					fmt.Printf("%s (config)\n", lo.Ternary(storedCfg.Context.Strategy != "", storedCfg.Context.Strategy, string(ctxwindow.None)))
+				}
+				return
+			}
+
+			strategy := *p.Strategy.Value()
+			if strategy == "default" {
+				strategy = ""
+			} else if _, err := ctxwindow.ParseStrategy(strategy); err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+			state.ContextStrategy = strategy
+			session.StoreSession(state)
+		},
+	}.ToCobra()
+}
+
+// contextWindow returns the context window of the configured model, or 0 if unknown
+func contextWindow(cfg config.Config) int {
+	if cfg.Context.ContextWindow > 0 {
+		return cfg.Context.ContextWindow
+	}
+	providerName := strings.ReplaceAll(strings.TrimSpace(cfg.Provider), "_", "-")
+	if providerName == "ollama" && cfg.Ollama.NumCtx > 0 {
+		return cfg.Ollama.NumCtx
+	}
+	caps, ok := capabilities.Lookup(cfg.Model(providerName))
+	if !ok {
+		return 0
+	}
+	return caps.ContextWindow
+}
+
+// fitContextWindow applies the context strategy of the session (or config) to the messages
+// about to be sent. The stored session history is never changed.
+func fitContextWindow(cfg config.Config, state session.State, provider domain.Provider, messages []domain.Message) []domain.Message {
+	strategy, err := ctxwindow.ParseStrategy(lo.Ternary(state.ContextStrategy != "", state.ContextStrategy, cfg.Context.Strategy))
+	if err != nil {
+		common.FailAndExit(1, err.Error())
+	}
+
+	window := contextWindow(cfg)
+	if window == 0 {
+		slog.Debug("Unknown context window for the current model, set context.context_window in the config to enable context management")
+		return messages
+	}
+	budget := window - common.CfgOrDefaultI(cfg.Context.ReserveOutputTokens, defaultReserveOutputTokens)
+
+	summarizer := func(toSummarize []domain.Message) (string, error) {
+		slog.Info(fmt.Sprintf("Summarizing %d older messages to fit the context window", len(toSummarize)))
+		return summarizeMessages(provider, toSummarize)
+	}
+
+	previous := loadContextSummary(state.SessionID)
+	res, err := ctxwindow.Fit(strategy, messages, budget, previous, summarizer)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to fit the session into the context window: %v", err))
+	}
+
+	if strategy == ctxwindow.None && res.EstimatedBefore > budget {
+		slog.Warn(fmt.Sprintf("The session is about %d tokens, more than the %d the model can take. Set a context strategy with ai context-strategy, or in the config.", res.EstimatedBefore, budget))
+	}
+	if res.Changed() {
+		slog.Info(fmt.Sprintf("Fit the session into the context window with %s: ~%d -> ~%d tokens (%d messages left out, %d attachments removed)",
+			strategy, res.EstimatedBefore, res.EstimatedAfter, res.Dropped, res.Stripped))
+	}
+	if res.Summary != nil && (previous == nil || *res.Summary != *previous) {
+		storeContextSummary(state.SessionID, *res.Summary)
+	}
+
+	return res.Messages
+}
+
+// summarizeMessages asks the provider for a summary of the conversation
+func summarizeMessages(provider domain.Provider, messages []domain.Message) (string, error) {
+	res, err := provider.BasicAsk(domain.Question{
+		Messages: append(append([]domain.Message{}, messages...), domain.Message{SourceType: domain.User, Content: summarizeInstruction}),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res.GetChoices()) == 0 {
+		return "", fmt.Errorf("no summary returned")
+	}
+	return res.GetChoices()[0].Message.Content, nil
+}
+
+// the summary is kept next to, but separate from, the session state, so that
+// the stored history stays intact
+func contextSummaryFile(sessionID string) string {
+	return session.Dir() + "/" + sessionID + "/context_summary.json"
+}
+
+func loadContextSummary(sessionID string) *ctxwindow.Summary {
+	summary, err := util.ReadFileAsJson[ctxwindow.Summary](contextSummaryFile(sessionID))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("Failed to read context summary: %v", err))
+		}
+		return nil
+	}
+	return &summary
+}
+
+func storeContextSummary(sessionID string, summary ctxwindow.Summary) {
+	err := os.MkdirAll(session.Dir()+"/"+sessionID, 0755)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to create session dir: %v", err))
+		return
+	}
+	bytes, err := json.Marshal(summary)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to marshal context summary: %v", err))
+		return
+	}
+	err = util.WriteFileAtomic(contextSummaryFile(sessionID), bytes, 0644)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to store context summary: %v", err))
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const continueInstruction = "Your previous answer was cut off. Continue exactly where it ended, " +
+	"without repeating anything and without any preamble."
+
+type ContinueParams struct {
+	config.CliSubcParams
+	Force bool `descr:"Send the request even if it exceeds a budget" default:"false" name:"force"`
+}
+
+func Continue() *cobra.Command {
+	return boa.CmdT[ContinueParams]{
+		Use:   "continue",
+		Short: "Resume the last answer, e.g. after it was truncated",
+		RunFunc: func(p *ContinueParams, cmd *cobra.Command, args []string) {
+
+			if p.Verbose.Value() {
+				slog.SetLogLoggerLevel(slog.LevelDebug)
+			}
+
+			cfgFilePath, storedCfg := config.LoadCfgFile()
+			cfg := config.ValidateCfg(cfgFilePath, storedCfg, p.ToCliParams())
+			state := session.LoadSession(session.GetSessionID(p.Session.GetOrElse("")))
+			provider := createProvider(cfg, state.SessionID)
+			lastAnswer, ok := state.LastAnswer()
+			if !ok {
+				common.FailAndExit(1, "Nothing to continue, the last message in the session is not an answer")
+			}
+
+			messages := state.MessageHistory()
+			if prefill, ok := provider.(domain.PrefillSupporter); ok && prefill.SupportsAssistantPrefill() {
+				// the last assistant message is used as the start of the new answer. Anthropic
+				// rejects prefills ending with whitespace, so we strip it here and in the stored answer
+				lastAnswer.Message.Content = strings.TrimRight(lastAnswer.Message.Content, " \t\r\n")
+				messages[len(messages)-1] = lastAnswer.Message
+			} else {
+				messages = append(messages, domain.Message{
+					SourceType: domain.User,
+					Content:    continueInstruction,
+				})
+			}
+
+			// checked before fitting the context window, which may summarize with a paid call
+			checkBudgets(cfg, state, estimateRequest(cfg, messages), p.Force)
+			messages = fitContextWindow(cfg, state, provider, messages)
+
+			started := time.Now()
+			answer := streamAnswer(provider.BasicAskStream(domain.Question{
+				Messages: messages,
+			}), true)
+			answer.Latency = time.Since(started)
+
+			state.InputTokensAccum += answer.InputTokens
+			state.InputTokens = answer.InputTokens
+			state.OutputTokensAccum += answer.OutputTokens
+			state.OutputTokens = answer.OutputTokens
+			lastAnswer.Message.Content += answer.Text
+			lastAnswer.StopReason = answer.StopReason
+			recordResponse(cfg, lastAnswer, answer)
+			recordCost(cfg, &state, lastAnswer, answer)
+
+			session.StoreSession(state)
+
+			warnIfTruncated(answer.StopReason)
+		},
+	}.ToCobra()
+}
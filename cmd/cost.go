@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/pricing"
+	"github.com/gigurra/ai/session"
+	"os"
+)
+
+// recordCost adds the cost of an answer to the history entry holding it and to the session
+// total, and prints the footer if enabled. Answers from models without a known price cost nothing.
+func recordCost(cfg config.Config, state *session.State, entry *session.HistoryEntry, answer streamedAnswer) {
+	price, known := cfg.Price(normalizeProviderName(cfg.Provider))
+	cost := price.Cost(answer.InputTokens, answer.CachedTokens, answer.OutputTokens)
+	entry.Cost += cost
+	state.CostAccum += cost
+
+	if !cfg.Pricing.Footer {
+		return
+	}
+	costStr := "unknown price"
+	if known {
+		costStr = pricing.FormatUSD(cost)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "[i=%d (%d cached), o=%d, %s, session total %s]\n",
+		answer.InputTokens, answer.CachedTokens, answer.OutputTokens, costStr, pricing.FormatUSD(state.CostAccum))
+}
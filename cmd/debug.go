@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/util"
+	"github.com/gigurra/ai/wirelog"
+	"github.com/spf13/cobra"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+func Debug() *cobra.Command {
+	return boa.Cmd{
+		Use:     "debug",
+		Short:   "Inspect raw requests and responses recorded with --wire-log or wire_log in the config",
+		SubCmds: []*cobra.Command{debugLast()},
+	}.ToCobra()
+}
+
+func debugLast() *cobra.Command {
+	p := config.CliSubcParams{}
+	return boa.Cmd{
+		Use:    "last",
+		Short:  "Pretty-print the latest recorded exchange of the current session",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			sessionID := session.GetSessionID(p.Session.GetOrElse(""))
+			files, err := wirelog.Files(wireLogDir(sessionID))
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to list wire log: %v", err))
+			}
+			if len(files) == 0 {
+				common.FailAndExit(1, fmt.Sprintf("Nothing recorded for session %s. Pass --wire-log, or set wire_log: true in the config", sessionID))
+			}
+
+			exchange, err := util.ReadFileAsJson[wirelog.Exchange](files[len(files)-1])
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to read wire log: %v", err))
+			}
+			fmt.Printf("# %s\n\n", files[len(files)-1])
+			printExchange(exchange)
+		},
+	}.ToCobra()
+}
+
+func printExchange(exchange wirelog.Exchange) {
+	fmt.Printf("%s %s (%s)\n", exchange.Request.Method, exchange.Request.URL, exchange.Time.Local().Format("2006-01-02 15:04:05"))
+	printHeaders(">", exchange.Request.Headers)
+	if exchange.Request.Body != "" {
+		fmt.Printf("\n%s\n", prettyJson(exchange.Request.Body))
+	}
+
+	if res := exchange.Response; res != nil {
+		fmt.Printf("\n< %d %s (%dms)\n", res.Status, http.StatusText(res.Status), exchange.DurationMs)
+		printHeaders("<", res.Headers)
+		for _, event := range res.Events {
+			fmt.Println()
+			if event.Event != "" {
+				fmt.Printf("event: %s\n", event.Event)
+			}
+			fmt.Printf("data: %s\n", prettyJson(event.Data))
+		}
+		if res.Body != "" {
+			fmt.Println()
+			// ndjson streams (ollama) are printed a line at a time
+			for _, line := range strings.Split(strings.TrimSpace(res.Body), "\n") {
+				fmt.Printf("%s\n", prettyJson(line))
+			}
+		}
+	}
+
+	if exchange.Error != "" {
+		fmt.Printf("\nerror: %s\n", exchange.Error)
+	}
+}
+
+func printHeaders(prefix string, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%s %s: %s\n", prefix, name, strings.Join(headers[name], ", "))
+	}
+}
+
+// prettyJson indents text if it is json, and returns it as is otherwise
+func prettyJson(text string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(text), "", "  "); err != nil {
+		return text
+	}
+	return buf.String()
+}
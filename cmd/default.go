@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/ctxwindow"
 	"github.com/gigurra/ai/domain"
-	"github.com/gigurra/ai/providers"
+	"github.com/gigurra/ai/schema"
 	"github.com/gigurra/ai/session"
 	"github.com/gigurra/ai/util"
 	"github.com/spf13/cobra"
 	"log/slog"
+	"os"
 	"strings"
+	"time"
 )
 
 func Default(cliParams *config.CliParams) func(cmd *cobra.Command, args []string) {
@@ -26,15 +29,13 @@ func Default(cliParams *config.CliParams) func(cmd *cobra.Command, args []string
 		cfgFilePath, storedCfg := config.LoadCfgFile()
 		cfg := config.ValidateCfg(cfgFilePath, storedCfg, cliParams)
 
-		provider := providers.CreateProvider(cfg)
-
 		stdInAttachment, err := util.ReadAllStdIn()
 		if err != nil {
 			common.FailAndExit(1, fmt.Sprintf("Failed to read attachment from stdin: %v", err))
 		}
 		if stdInAttachment != "" {
 			if question != "" {
-				footer := fmt.Sprintf("\n Attached additional info/data: \n %s", stdInAttachment)
+				footer := ctxwindow.AttachmentMarker + " " + stdInAttachment
 				question = fmt.Sprintf("%s\n%s", question, footer)
 			} else {
 				question = stdInAttachment
@@ -46,56 +47,169 @@ func Default(cliParams *config.CliParams) func(cmd *cobra.Command, args []string
 		}
 
 		state := session.LoadSession(session.GetSessionID(cliParams.Session.GetOrElse("")))
-		messageHistory := state.MessageHistory()
-
 		newMessage := domain.Message{
 			SourceType: domain.User,
 			Content:    question,
 		}
 
-		stream := provider.BasicAskStream(domain.Question{
-			Messages: append(messageHistory, newMessage),
-		})
-
-		inputTokens := 0
-		outputTokens := 0
-		accum := strings.Builder{}
-		for {
-			res, hasMore := <-stream
-			if !hasMore {
-				if len(accum.String()) == 0 {
-					common.FailAndExit(1, "No response handled from ai provider")
-				}
-				break // stream done
-			}
-			if res.Err != nil {
-				common.FailAndExit(1, fmt.Sprintf("Failed to receive stream response: %v", res.Err))
+		// the retrieved excerpts are only sent with this question, the history keeps the question as asked
+		sentMessage := newMessage
+		if cliParams.Rag.HasValue() {
+			sentMessage.Content = retrieveRagContext(*cliParams.Rag.Value(), question, cliParams.RagTopK.Value(), cfg.WireLog, state.SessionID)
+		}
+		provider := createProvider(cfg, state.SessionID)
+		messages := append(state.MessageHistory(), sentMessage)
+
+		// checked before fitting the context window, which may summarize with a paid call.
+		// Fitting only shrinks the request, so the estimate is an upper bound
+		estimate := estimateRequest(cfg, messages)
+		confirmRequestSize(cfg, estimate, cliParams.Yes.Value())
+		checkBudgets(cfg, state, estimate, cliParams.Force.Value())
+
+		messages = fitContextWindow(cfg, state, provider, messages)
+
+		var answer streamedAnswer
+		started := time.Now()
+		if cliParams.Schema.HasValue() {
+			responseSchema, err := schema.Load(*cliParams.Schema.Value())
+			if err != nil {
+				common.FailAndExit(1, err.Error())
 			}
+			answer = askStructured(provider, messages, responseSchema, cliParams.SchemaRetries.Value())
+		} else {
+			answer = streamAnswer(provider.BasicAskStream(domain.Question{
+				Messages: messages,
+			}), true)
+		}
+		answer.Latency = time.Since(started)
 
-			inputTokens += res.Resp.GetUsage().PromptTokens
-			outputTokens += res.Resp.GetUsage().CompletionTokens
+		state.InputTokensAccum += answer.InputTokens
+		state.InputTokens = answer.InputTokens
+		state.OutputTokensAccum += answer.OutputTokens
+		state.OutputTokens = answer.OutputTokens
+		state.AddMessage(newMessage)
+		lastAnswer := state.AddAnswer(domain.Message{
+			SourceType: domain.Assistant,
+			Content:    answer.Text,
+		}, answer.StopReason)
+		recordResponse(cfg, lastAnswer, answer)
+		recordCost(cfg, &state, lastAnswer, answer)
 
-			if len(res.Resp.GetChoices()) == 0 {
-				continue
+		session.StoreSession(state)
+
+		warnIfTruncated(answer.StopReason)
+	}
+}
+
+type streamedAnswer struct {
+	Text         string
+	InputTokens  int
+	OutputTokens int
+	CachedTokens int
+	StopReason   domain.StopReason
+	Latency      time.Duration // set by the caller, from sending the request until the whole answer was received
+}
+
+// recordResponse stores what produced an answer in its history entry. Continued answers
+// add up usage and latency, and keep the provider and model of the latest part.
+func recordResponse(cfg config.Config, entry *session.HistoryEntry, answer streamedAnswer) {
+	entry.Timestamp = time.Now()
+	entry.Provider = normalizeProviderName(cfg.Provider)
+	entry.Model = cfg.Model(entry.Provider)
+	if entry.Usage == nil {
+		entry.Usage = &session.EntryUsage{}
+	}
+	entry.Usage.InputTokens += answer.InputTokens
+	entry.Usage.CachedTokens += answer.CachedTokens
+	entry.Usage.OutputTokens += answer.OutputTokens
+	entry.LatencyMs += answer.Latency.Milliseconds()
+}
+
+// streamAnswer collects the answer, optionally echoing it to stdout as it arrives
+func streamAnswer(stream <-chan domain.RespChunk, echo bool) streamedAnswer {
+	answer := streamedAnswer{}
+	accum := strings.Builder{}
+	for {
+		res, hasMore := <-stream
+		if !hasMore {
+			if len(accum.String()) == 0 {
+				common.FailAndExit(1, "No response handled from ai provider")
 			}
+			break // stream done
+		}
+		if res.Err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to receive stream response: %v", res.Err))
+		}
 
-			accum.WriteString(res.Resp.GetChoices()[0].Message.Content)
-			fmt.Printf("%s", res.Resp.GetChoices()[0].Message.Content)
+		answer.InputTokens += res.Resp.GetUsage().PromptTokens
+		answer.OutputTokens += res.Resp.GetUsage().CompletionTokens
+		answer.CachedTokens += res.Resp.GetUsage().CachedTokens
+		if res.Resp.GetStopReason() != domain.StopReasonUnknown {
+			answer.StopReason = res.Resp.GetStopReason()
+		}
 
+		if len(res.Resp.GetChoices()) == 0 {
+			continue
+		}
+
+		accum.WriteString(res.Resp.GetChoices()[0].Message.Content)
+		if echo {
+			fmt.Printf("%s", res.Resp.GetChoices()[0].Message.Content)
 		}
 
+	}
+
+	if echo {
 		fmt.Printf("\n")
+	}
 
-		state.InputTokensAccum += inputTokens
-		state.InputTokens = inputTokens
-		state.OutputTokensAccum += outputTokens
-		state.OutputTokens = outputTokens
-		state.AddMessage(newMessage)
-		state.AddMessage(domain.Message{
-			SourceType: domain.Assistant,
-			Content:    accum.String(),
-		})
+	answer.Text = accum.String()
+	return answer
+}
 
-		session.StoreSession(state)
+func warnIfTruncated(stopReason domain.StopReason) {
+	if stopReason.IsTruncated() {
+		_, _ = fmt.Fprintf(os.Stderr, "\nWARNING: the answer was truncated (%s). Run `ai continue` to resume it.\n", stopReason)
+	}
+}
+
+// askStructured asks for an answer matching the schema, and feeds validation errors back to the
+// model until it gets it right or runs out of retries. Only the validated JSON is printed.
+func askStructured(provider domain.Provider, messages []domain.Message, responseSchema schema.Schema, maxRetries int) streamedAnswer {
+	total := streamedAnswer{}
+	for attempt := 0; ; attempt++ {
+		answer := streamAnswer(provider.BasicAskStream(domain.Question{
+			Messages:       messages,
+			ResponseSchema: responseSchema.Raw,
+		}), false)
+
+		total.InputTokens += answer.InputTokens
+		total.OutputTokens += answer.OutputTokens
+		total.CachedTokens += answer.CachedTokens
+		total.StopReason = answer.StopReason
+
+		document, err := responseSchema.Validate(answer.Text)
+		if err == nil {
+			fmt.Printf("%s\n", document)
+			total.Text = document
+			return total
+		}
+
+		if attempt >= maxRetries {
+			common.FailAndExit(1, fmt.Sprintf("Answer did not match schema %s after %d attempts: %v", responseSchema.Path, attempt+1, err))
+		}
+
+		slog.Warn(fmt.Sprintf("Answer did not match schema, retrying (%d/%d): %v", attempt+1, maxRetries, err))
+		messages = append(messages,
+			domain.Message{
+				SourceType: domain.Assistant,
+				Content:    answer.Text,
+			},
+			domain.Message{
+				SourceType: domain.User,
+				Content: fmt.Sprintf("Your answer is invalid: %v\n"+
+					"Reply again with only a corrected JSON document matching the schema.", err),
+			},
+		)
 	}
 }
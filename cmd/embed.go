@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/domain"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type embeddingLine struct {
+	Index     int       `json:"index"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+func Embed() *cobra.Command {
+	var p struct {
+		Provider  boa.Optional[string] `descr:"AI provider to use" name:"provider" env:"AI_PROVIDER" short:"p"`
+		BatchSize boa.Required[int]    `descr:"Max texts per request (0 = provider max)" default:"0" name:"batch-size"`
+	}
+	return boa.Cmd{
+		Use:    "embed",
+		Short:  "Embed each non-empty line of stdin, writing one JSON vector per line to stdout",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			cfgFilePath, storedCfg := config.LoadCfgFile()
+			cfg := config.ValidateCfg(cfgFilePath, storedCfg, &config.CliParams{Provider: p.Provider})
+			embedder, err := createEmbedder(cfg, "")
+			if err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+
+			texts := readStdInLines()
+			if len(texts) == 0 {
+				common.FailAndExit(1, "Nothing to embed, expected one text per line on stdin")
+			}
+
+			res, err := domain.EmbedBatched(embedder, texts, p.BatchSize.Value())
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to embed texts: %v", err))
+			}
+
+			writer := bufio.NewWriter(os.Stdout)
+			encoder := json.NewEncoder(writer)
+			for i, vector := range res.Vectors {
+				err := encoder.Encode(embeddingLine{Index: i, Text: texts[i], Embedding: vector})
+				if err != nil {
+					common.FailAndExit(1, fmt.Sprintf("Failed to write embedding: %v", err))
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to write embeddings: %v", err))
+			}
+
+			slog.Info(fmt.Sprintf("Embedded %d texts with %s (%d dimensions)", len(res.Vectors), res.Model, res.Dimensions))
+		},
+	}.ToCobra()
+}
+
+func readStdInLines() []string {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to read stdin: %v", err))
+	}
+	return lines
+}
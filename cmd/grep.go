@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/search"
+	"github.com/gigurra/ai/session"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"regexp"
+	"time"
+)
+
+func Grep() *cobra.Command {
+	var p struct {
+		Pattern    boa.Required[string] `descr:"Regular expression to search for" positional:"true"`
+		Role       boa.Optional[string] `descr:"Only search messages from this role" name:"role" alts:"system,user,assistant"`
+		Since      boa.Optional[string] `descr:"Only search sessions updated on or after this date (YYYY-MM-DD)" name:"since"`
+		Until      boa.Optional[string] `descr:"Only search sessions created on or before this date (YYYY-MM-DD)" name:"until"`
+		Context    boa.Required[int]    `descr:"Lines of context around each match" default:"0" name:"context" short:"C"`
+		IgnoreCase boa.Required[bool]   `descr:"Case insensitive matching" default:"false" name:"ignore-case" short:"i"`
+	}
+	return boa.Cmd{
+		Use:    "grep",
+		Short:  "Search the history of all stored sessions with a regular expression",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			pattern := p.Pattern.Value()
+			if p.IgnoreCase.Value() {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Invalid regex: %v", err))
+			}
+
+			since := parseDateFlag(p.Since, "since")
+			until := parseDateFlag(p.Until, "until")
+			if !until.IsZero() {
+				until = until.AddDate(0, 0, 1) // inclusive
+			}
+
+			headers := session.ListSessions()
+			headersByID := map[string]session.Header{}
+			for _, header := range headers {
+				headersByID[header.SessionID] = header
+			}
+
+			idx, err := search.LoadGrepIndex()
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Rebuilding grep index: %v", err))
+				idx = search.GrepIndex{Sessions: map[string]time.Time{}, Postings: map[string][]string{}}
+			}
+			if idx.Sync(headers, session.LoadSession) {
+				if err := idx.Store(); err != nil {
+					slog.Warn(fmt.Sprintf("Failed to store grep index: %v", err))
+				}
+			}
+
+			candidates, err := idx.Candidates(pattern)
+			if err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+
+			first := true
+			for _, sessionID := range candidates {
+				header := headersByID[sessionID]
+				if !since.IsZero() && header.UpdatedAt.Before(since) {
+					continue
+				}
+				if !until.IsZero() && !header.CreatedAt.Before(until) {
+					continue
+				}
+
+				state := session.LoadSession(sessionID)
+				for i, entry := range state.History {
+					if p.Role.HasValue() && entry.Message.SourceType != domain.SourceType(*p.Role.Value()) {
+						continue
+					}
+					lines := search.GrepText(re, entry.Message.Content, p.Context.Value())
+					for j, line := range lines {
+						startsGroup := j == 0 || lines[j-1].LineNo != line.LineNo-1
+						if startsGroup && !first && p.Context.Value() > 0 {
+							fmt.Printf("--\n")
+						}
+						first = false
+						separator := "-"
+						if line.IsMatch {
+							separator = ":"
+						}
+						fmt.Printf("%s:#%d:%s:%d%s %s\n", sessionID, i, entry.Message.SourceType, line.LineNo, separator, line.Text)
+					}
+				}
+			}
+		},
+	}.ToCobra()
+}
+
+func parseDateFlag(flag boa.Optional[string], name string) time.Time {
+	if !flag.HasValue() {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(time.DateOnly, *flag.Value(), time.Local)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Invalid --%s date, expected YYYY-MM-DD: %v", name, err))
+	}
+	return t
+}
@@ -5,9 +5,13 @@ import (
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/pricing"
 	"github.com/gigurra/ai/session"
 	"github.com/spf13/cobra"
 	"log/slog"
+	"strings"
+	"time"
 )
 
 func History() *cobra.Command {
@@ -15,6 +19,7 @@ func History() *cobra.Command {
 	type HistoryCmdParams struct {
 		config.CliSubcParams
 		Format boa.Required[string] `name:"format" descr:"Output format. Valid options: pretty or yaml" default:"pretty"`
+		Meta   boa.Required[bool]   `name:"meta" short:"m" descr:"Show when, by which model and at what cost each message was produced" default:"false"`
 	}
 
 	p := HistoryCmdParams{}
@@ -32,13 +37,23 @@ func History() *cobra.Command {
 				if entry.Type == "message" {
 					if p.Format.Value() == "pretty" {
 						fmt.Printf("\n----------------------\n")
-						fmt.Printf("|  %s\n", entry.Message.SourceType)
+						if entry.StopReason.IsTruncated() {
+							fmt.Printf("|  %s (truncated: %s)\n", entry.Message.SourceType, entry.StopReason)
+						} else {
+							fmt.Printf("|  %s\n", entry.Message.SourceType)
+						}
+						if meta := entryMeta(entry); p.Meta.Value() && meta != "" {
+							fmt.Printf("|  %s\n", meta)
+						}
 						fmt.Printf("-------------\n")
 						fmt.Printf("%s\n", entry.Message.Content)
 					} else if p.Format.Value() == "yaml" {
 						if oneMsgPrinted {
 							fmt.Printf("---\n")
 						}
+						if meta := entryMeta(entry); p.Meta.Value() && meta != "" {
+							fmt.Printf("# %s\n", meta)
+						}
 						fmt.Printf("%s", entry.Message.ToYaml())
 					} else {
 						common.FailAndExit(1, fmt.Sprintf("Unsupported format: %s", p.Format.Value()))
@@ -51,3 +66,35 @@ func History() *cobra.Command {
 		},
 	}.ToCobra()
 }
+
+// entryMeta describes what is known about how an entry was produced, entries stored before
+// it was recorded have nothing
+func entryMeta(entry session.HistoryEntry) string {
+	var parts []string
+	if !entry.Timestamp.IsZero() {
+		parts = append(parts, entry.Timestamp.Local().Format("2006-01-02 15:04:05"))
+	}
+	if entry.Model != "" {
+		parts = append(parts, entry.Provider+"/"+entry.Model)
+	}
+	if entry.Usage != nil {
+		usage := fmt.Sprintf("i=%d", entry.Usage.InputTokens)
+		if entry.Usage.CachedTokens > 0 {
+			usage += fmt.Sprintf(" (%d cached)", entry.Usage.CachedTokens)
+		}
+		parts = append(parts, usage+fmt.Sprintf(", o=%d", entry.Usage.OutputTokens))
+	}
+	if entry.LatencyMs > 0 {
+		parts = append(parts, (time.Duration(entry.LatencyMs) * time.Millisecond).String())
+	}
+	if entry.StopReason != domain.StopReasonUnknown {
+		parts = append(parts, string(entry.StopReason))
+	}
+	if entry.Cost > 0 {
+		parts = append(parts, pricing.FormatUSD(entry.Cost))
+	}
+	if entry.ID != "" {
+		parts = append(parts, "id "+entry.ID)
+	}
+	return strings.Join(parts, ", ")
+}
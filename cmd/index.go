@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/rag"
+	"github.com/spf13/cobra"
+	"log/slog"
+)
+
+func Index() *cobra.Command {
+	var p struct {
+		Dir      boa.Required[string] `descr:"Directory to index" positional:"true"`
+		Provider boa.Optional[string] `descr:"AI provider to use for embeddings" name:"provider" env:"AI_PROVIDER" short:"p"`
+	}
+	return boa.Cmd{
+		Use:    "index",
+		Short:  "Index a directory of text and code files for use with --rag",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			embedder, err := configuredEmbedder(p.Provider, false, "")
+			if err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+			idx, stats := updateRagIndex(p.Dir.Value(), embedder)
+			fmt.Printf("Indexed %s: %d files added, %d changed, %d unchanged, %d removed, %d chunks embedded (%s)\n",
+				p.Dir.Value(), stats.Added, stats.Changed, stats.Unchanged, stats.Removed, stats.EmbeddedChunks, idx.EmbeddingModel)
+		},
+	}.ToCobra()
+}
+
+// updateRagIndex incrementally updates and stores the index of dir
+func updateRagIndex(dir string, embedder domain.Embedder) (rag.Index, rag.UpdateStats) {
+	idx, err := rag.Load(dir)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Rebuilding index: %v", err))
+		idx = rag.Index{Root: dir, Files: map[string]rag.File{}}
+	}
+	stats, err := idx.Update(embedder)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to index %s: %v", dir, err))
+	}
+	err = idx.Store()
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to store index of %s: %v", dir, err))
+	}
+	return idx, stats
+}
+
+// retrieveRagContext brings the index of dir up to date and frames the question with
+// the chunks most similar to it. The embedding calls belong to sessionID.
+func retrieveRagContext(dir string, question string, topK int, wireLog bool, sessionID string) string {
+	embedder, err := configuredEmbedder(boa.Optional[string]{}, wireLog, sessionID)
+	if err != nil {
+		common.FailAndExit(1, err.Error())
+	}
+
+	idx, stats := updateRagIndex(dir, embedder)
+	if stats.EmbeddedChunks > 0 {
+		slog.Info(fmt.Sprintf("Embedded %d new chunks in %s", stats.EmbeddedChunks, dir))
+	}
+
+	queryEmbedding, err := embedder.Embed([]string{question})
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to embed question: %v", err))
+	}
+	if idx.EmbeddingModel != "" && queryEmbedding.Model != idx.EmbeddingModel {
+		common.FailAndExit(1, fmt.Sprintf("The index of %s was built with %s, but the question was embedded with %s. Remove %s to rebuild it.",
+			dir, idx.EmbeddingModel, queryEmbedding.Model, rag.IndexFile(dir)))
+	}
+
+	if len(queryEmbedding.Vectors) == 0 {
+		common.FailAndExit(1, "Embedding the question returned no vector")
+	}
+	results := idx.Retrieve(queryEmbedding.Vectors[0], topK)
+	if len(results) == 0 {
+		slog.Warn(fmt.Sprintf("Nothing indexed in %s, asking without context", dir))
+		return question
+	}
+	for _, result := range results {
+		slog.Debug(fmt.Sprintf("Retrieved %s:%d-%d (score %.3f)", result.Path, result.StartLine, result.EndLine, result.Score))
+	}
+
+	return rag.FrameQuestion(question, results)
+}
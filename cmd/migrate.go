@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/session"
+	"github.com/spf13/cobra"
+)
+
+func Migrate() *cobra.Command {
+	var p struct {
+		Check bool `descr:"Only verify that every stored session parses, without rewriting any" default:"false" name:"check"`
+	}
+	return boa.Cmd{
+		Use:    "migrate",
+		Short:  "Upgrade all stored sessions to the current schema version",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			checks := session.CheckSessions()
+
+			failed, outdated, migrated := 0, 0, 0
+			for _, check := range checks {
+				if check.Err != nil {
+					failed++
+					fmt.Printf("%s: FAILED: %v\n", check.SessionID, check.Err)
+					continue
+				}
+				if check.Version == session.CurrentSchemaVersion {
+					continue
+				}
+				if p.Check {
+					outdated++
+					fmt.Printf("%s: schema version %d, will be upgraded to %d\n", check.SessionID, check.Version, session.CurrentSchemaVersion)
+				} else {
+					session.MigrateSession(check.SessionID)
+					migrated++
+					fmt.Printf("%s: upgraded from schema version %d to %d\n", check.SessionID, check.Version, session.CurrentSchemaVersion)
+				}
+			}
+
+			fmt.Printf("%d sessions checked, %d failed, %d outdated, %d upgraded (schema version %d)\n",
+				len(checks), failed, outdated, migrated, session.CurrentSchemaVersion)
+			if failed > 0 {
+				common.FailAndExit(1, fmt.Sprintf("%d sessions could not be parsed", failed))
+			}
+		},
+	}.ToCobra()
+}
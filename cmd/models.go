@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/providers"
+	"github.com/gigurra/ai/providers/ollama_provider"
+	"github.com/spf13/cobra"
+)
+
+func Models() *cobra.Command {
+	var p struct {
+		Verbose  boa.Required[bool]   `descr:"Verbose output" short:"v" default:"false" name:"verbose"`
+		Provider boa.Optional[string] `descr:"AI provider to use" name:"provider" env:"AI_PROVIDER" short:"p"`
+		Pull     boa.Optional[string] `descr:"Model to pull (ollama only)" name:"pull"`
+	}
+	return boa.Cmd{
+		Use:    "models",
+		Short:  "List the models available from the current provider",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			cfgFilePath, storedCfg := config.LoadCfgFile()
+			cfg := config.ValidateCfg(cfgFilePath, storedCfg, &config.CliParams{Provider: p.Provider})
+			provider := providers.CreateProvider(cfg)
+
+			ollama, isOllama := provider.(*ollama_provider.Provider)
+
+			if p.Pull.HasValue() {
+				if !isOllama {
+					common.FailAndExit(1, "Pulling models is only supported by the ollama provider")
+				}
+				pullOllamaModel(ollama, *p.Pull.Value())
+				return
+			}
+
+			if isOllama && p.Verbose.Value() {
+				infos, err := ollama.ModelInfos()
+				if err != nil {
+					common.FailAndExit(1, fmt.Sprintf("Failed to list models: %v", err))
+				}
+				for _, info := range infos {
+					fmt.Printf("%s (%s, %s, %s, modified %s)\n", info.Name, formatBytes(info.Size), info.Details.ParameterSize, info.Details.QuantizationLevel, info.ModifiedAt)
+				}
+				return
+			}
+
+			models, err := provider.ListModels()
+			if err != nil {
+				common.FailAndExit(1, fmt.Sprintf("Failed to list models: %v", err))
+			}
+			for _, model := range models {
+				fmt.Printf("%s\n", model)
+			}
+		},
+	}.ToCobra()
+}
+
+func pullOllamaModel(ollama *ollama_provider.Provider, model string) {
+	lastStatus := ""
+	err := ollama.Pull(model, func(progress ollama_provider.PullProgress) {
+		if progress.Total > 0 {
+			fmt.Printf("\r%s: %d%% (%s/%s)   ", progress.Status, progress.Completed*100/progress.Total, formatBytes(progress.Completed), formatBytes(progress.Total))
+			lastStatus = progress.Status
+			return
+		}
+		if progress.Status != lastStatus {
+			if lastStatus != "" {
+				fmt.Printf("\n")
+			}
+			fmt.Printf("%s", progress.Status)
+			lastStatus = progress.Status
+		}
+	})
+	fmt.Printf("\n")
+	if err != nil {
+		common.FailAndExit(1, err.Error())
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/config"
 	"github.com/gigurra/ai/domain"
-	"github.com/gigurra/ai/providers"
 	"github.com/gigurra/ai/session"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
@@ -29,7 +28,7 @@ func NameAll() *cobra.Command {
 
 			cfgFilePath, storedCfg := config.LoadCfgFile()
 			cfg := config.ValidateCfg(cfgFilePath, storedCfg, &config.CliParams{Provider: p.Provider})
-			provider := providers.CreateProvider(cfg)
+			provider := createProvider(cfg, "")
 
 			sessionsToRename := lo.Filter(sessions, func(s session.Header, _ int) bool {
 				return isUUID(s.SessionID)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/ctxwindow"
 	"github.com/gigurra/ai/domain"
 	"github.com/gigurra/ai/session"
 	"github.com/gigurra/ai/util"
@@ -29,7 +30,7 @@ func Prep() *cobra.Command {
 			}
 			if stdInAttachment != "" {
 				if question != "" {
-					footer := fmt.Sprintf("\n Attached additional info/data: \n %s", stdInAttachment)
+					footer := ctxwindow.AttachmentMarker + " " + stdInAttachment
 					question = fmt.Sprintf("%s\n%s", question, footer)
 				} else {
 					question = stdInAttachment
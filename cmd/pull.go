@@ -21,6 +21,7 @@ func Pull() *cobra.Command {
 		Args:   cobra.MinimumNArgs(0),
 		RunFunc: func(cmd *cobra.Command, args []string) {
 
+			requireFSStorage()
 			sessionsDir := session.Dir()
 			fmt.Printf("Pulling latest sessions from git remote -> %s\n", sessionsDir)
 
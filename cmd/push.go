@@ -22,6 +22,7 @@ func Push() *cobra.Command {
 		Args:   cobra.MinimumNArgs(0),
 		RunFunc: func(cmd *cobra.Command, args []string) {
 
+			requireFSStorage()
 			sessionsDir := session.Dir()
 			fmt.Printf("Pushing latest sessions %s -> git remote\n", sessionsDir)
 
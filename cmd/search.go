@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/search"
+	"github.com/gigurra/ai/session"
+	"github.com/spf13/cobra"
+	"log/slog"
+)
+
+func Search() *cobra.Command {
+	var p struct {
+		Query    boa.Required[string] `descr:"What to search for" positional:"true"`
+		Provider boa.Optional[string] `descr:"AI provider to use for embeddings" name:"provider" env:"AI_PROVIDER" short:"p"`
+		Limit    boa.Required[int]    `descr:"Max sessions to show" default:"10" name:"limit" short:"n"`
+		Messages boa.Required[int]    `descr:"Max matching messages to show per session" default:"3" name:"messages" short:"m"`
+		Lexical  boa.Required[bool]   `descr:"Use keyword (BM25) ranking instead of embeddings" default:"false" name:"lexical"`
+		Set      boa.Required[bool]   `descr:"Set the best matching session as the current session" default:"false" name:"set"`
+	}
+	return boa.Cmd{
+		Use:    "search",
+		Short:  "Search stored sessions, ranked by similarity to the query",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			query := p.Query.Value()
+
+			idx, err := search.LoadIndex()
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Rebuilding search index: %v", err))
+				idx = search.Index{Sessions: map[string]search.SessionIndex{}}
+			}
+			changed := idx.SyncSessions(session.ListSessions(), session.LoadSession)
+
+			hits := []search.Hit(nil)
+			if !p.Lexical.Value() {
+				semanticHits, semanticChanged, ok := semanticSearch(&idx, query, p.Provider)
+				changed = changed || semanticChanged
+				if ok {
+					hits = semanticHits
+				}
+			}
+			if hits == nil {
+				hits = idx.LexicalSearch(query)
+			}
+
+			if changed {
+				if err := idx.Store(); err != nil {
+					slog.Warn(fmt.Sprintf("Failed to store search index: %v", err))
+				}
+			}
+
+			results := search.GroupBySession(hits, p.Messages.Value())
+			if len(results) > p.Limit.Value() {
+				results = results[:p.Limit.Value()]
+			}
+			if len(results) == 0 {
+				fmt.Printf("No matching sessions\n")
+				return
+			}
+
+			terms := search.Tokenize(query)
+			for _, result := range results {
+				fmt.Printf("%s (score %.3f)\n", result.SessionID, result.Score)
+				for _, hit := range result.Hits {
+					fmt.Printf("  #%d [%s] %s\n", hit.MessageIndex, hit.Role, search.Snippet(hit.Text, terms, 100))
+				}
+			}
+
+			if p.Set.Value() {
+				session.SetSession(results[0].SessionID)
+				fmt.Printf("Current session set to: %s\n", results[0].SessionID)
+			}
+		},
+	}.ToCobra()
+}
+
+// semanticSearch embeds the query and any messages not yet embedded. Returns false if no
+// embedder can be created or embedding fails, so the caller can fall back to lexical search.
+func semanticSearch(idx *search.Index, query string, providerOverride boa.Optional[string]) ([]search.Hit, bool, bool) {
+	embedder, err := configuredEmbedder(providerOverride, false, "")
+	if err != nil {
+		slog.Info(fmt.Sprintf("No embedding provider available, using keyword search: %v", err))
+		return nil, false, false
+	}
+
+	queryEmbedding, err := embedder.Embed([]string{query})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to embed query, using keyword search: %v", err))
+		return nil, false, false
+	}
+
+	n, err := idx.EmbedMissing(embedder, queryEmbedding.Model)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to embed session messages, using keyword search: %v", err))
+		return nil, false, false
+	}
+	if n > 0 {
+		slog.Info(fmt.Sprintf("Embedded %d new messages", n))
+	}
+
+	if len(queryEmbedding.Vectors) == 0 {
+		common.FailAndExit(1, "Embedding the query returned no vector")
+	}
+	return idx.SemanticSearch(queryEmbedding.Vectors[0]), n > 0, true
+}
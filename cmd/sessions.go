@@ -5,13 +5,17 @@ import (
 
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/pricing"
 	"github.com/gigurra/ai/session"
 	"github.com/spf13/cobra"
 )
 
 type SessionsParams struct {
 	config.CliSubcParams
-	Format string `descr:"Output format" name:"format" default:"text" short:"f" alts:"text,table"`
+	Format     string               `descr:"Output format" name:"format" default:"text" short:"f" alts:"text,table"`
+	Containing boa.Optional[string] `descr:"Only sessions with a message containing this text (case insensitive)" name:"containing"`
+	Since      boa.Optional[string] `descr:"Only sessions updated on or after this date (YYYY-MM-DD)" name:"since"`
+	Until      boa.Optional[string] `descr:"Only sessions created on or before this date (YYYY-MM-DD)" name:"until"`
 }
 
 func Sessions() *cobra.Command {
@@ -20,7 +24,15 @@ func Sessions() *cobra.Command {
 		Short:       "List all stored sessions",
 		ParamEnrich: config.CliParamEnricher,
 		RunFunc: func(p *SessionsParams, cmd *cobra.Command, args []string) {
-			sessions := session.ListSessions()
+			query := session.Query{
+				Text:  p.Containing.GetOrElse(""),
+				Since: parseDateFlag(p.Since, "since"),
+				Until: parseDateFlag(p.Until, "until"),
+			}
+			if !query.Until.IsZero() {
+				query.Until = query.Until.AddDate(0, 0, 1) // inclusive
+			}
+			sessions := session.SearchSessions(query)
 			currentSession := session.GetSessionID(p.Session.GetOrElse(""))
 			if p.Verbose.Value() {
 				for _, s := range sessions {
@@ -28,7 +40,7 @@ func Sessions() *cobra.Command {
 					if s.SessionID == currentSession {
 						currentSessionSuffix = " [ *current* ]"
 					}
-					fmt.Printf("%s (i=%d/%d, o=%d/%d, created %v)%s\n", s.SessionID, s.InputTokens, s.InputTokensAccum, s.OutputTokens, s.OutputTokensAccum, s.CreatedAt.Format("2006-01-02 15:04:05"), currentSessionSuffix)
+					fmt.Printf("%s (i=%d/%d, o=%d/%d, %s, created %v)%s\n", s.SessionID, s.InputTokens, s.InputTokensAccum, s.OutputTokens, s.OutputTokensAccum, pricing.FormatUSD(s.CostAccum), s.CreatedAt.Format("2006-01-02 15:04:05"), currentSessionSuffix)
 				}
 			} else {
 				for _, s := range sessions {
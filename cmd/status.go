@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/pricing"
+	"github.com/gigurra/ai/providers/azure_openai_provider"
+	"github.com/gigurra/ai/providers/google_cloud_provider"
+	"github.com/gigurra/ai/providers/token_cache"
 	"github.com/gigurra/ai/session"
 	"github.com/spf13/cobra"
 	"strings"
+	"time"
 )
 
 func Status() *cobra.Command {
@@ -23,10 +28,38 @@ func Status() *cobra.Command {
 			fmt.Printf("current provider: %s\n", provider)
 			fmt.Printf("current model: %s\n", cfgInFile.Model(provider))
 			fmt.Printf("config file: %s\n", config.CfgFilePath())
+			fmt.Printf("storage: %s\n", session.ActiveStore().Name())
 			fmt.Printf("storage dir: %s\n", session.Dir())
 			fmt.Printf("lookup dir: %s\n", session.LookupDir())
-			fmt.Printf("current session: %s (i=%d/%d, o=%d/%d, created %v)\n", s.SessionID, s.InputTokens, s.InputTokensAccum, s.OutputTokens, s.OutputTokensAccum, s.CreatedAt.Format("2006-01-02 15:04:05"))
+			fmt.Printf("current session: %s (i=%d/%d, o=%d/%d, %s, created %v)\n", s.SessionID, s.InputTokens, s.InputTokensAccum, s.OutputTokens, s.OutputTokensAccum, pricing.FormatUSD(s.CostAccum), s.CreatedAt.Format("2006-01-02 15:04:05"))
 			fmt.Printf("current session file: %s\n", s.StateFile)
+			if s.Compaction != nil {
+				fmt.Printf("last compacted: %v (~%d -> ~%d tokens, original in archive %d)\n", s.Compaction.At.Local().Format("2006-01-02 15:04:05"), s.Compaction.TokensBefore, s.Compaction.TokensAfter, s.Compaction.Archive)
+			}
+			switch provider {
+			case "google-cloud":
+				printAccessTokenStatus(google_cloud_provider.TokenCacheKey(cfgInFile.GoogleCloud.ProjectID))
+			case "vertex-anthropic":
+				printAccessTokenStatus(google_cloud_provider.TokenCacheKey(cfgInFile.VertexAnthropic.ProjectID))
+			case "azure-openai":
+				if cfgInFile.AzureOpenAI.APIKeyCmd != "" {
+					printAccessTokenStatus(azure_openai_provider.TokenCacheKey(cfgInFile.AzureOpenAI))
+				}
+			}
 		},
 	}.ToCobra()
 }
+
+func printAccessTokenStatus(tokenCacheKey string) {
+	token, ok := token_cache.Peek(tokenCacheKey)
+	if !ok {
+		fmt.Printf("access token: not cached\n")
+		return
+	}
+	expiresIn := time.Until(token.ExpiresAt).Round(time.Second)
+	if token.IsValid() {
+		fmt.Printf("access token: expires %v (in %v)\n", token.ExpiresAt.Local().Format("2006-01-02 15:04:05"), expiresIn)
+	} else {
+		fmt.Printf("access token: expires %v (in %v), will be refreshed on next call\n", token.ExpiresAt.Local().Format("2006-01-02 15:04:05"), expiresIn)
+	}
+}
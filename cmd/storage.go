@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/session"
+	"github.com/spf13/cobra"
+)
+
+func Storage() *cobra.Command {
+	return boa.Cmd{
+		Use:     "storage",
+		Short:   "Show or change where sessions are stored",
+		SubCmds: []*cobra.Command{storageMigrate()},
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			store := session.ActiveStore()
+			fmt.Printf("backend: %s\n", store.Name())
+			switch store.Name() {
+			case session.BackendSQLite:
+				fmt.Printf("database: %s\n", session.SQLiteFile())
+			default:
+				fmt.Printf("dir: %s\n", session.Dir())
+			}
+		},
+	}.ToCobra()
+}
+
+func storageMigrate() *cobra.Command {
+	var p struct {
+		To boa.Required[string] `descr:"Backend to move all sessions to" name:"to" alts:"fs,sqlite"`
+	}
+	return boa.Cmd{
+		Use:    "migrate",
+		Short:  "Move all stored sessions, with their archives, to another storage backend",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			to := p.To.Value()
+			if to != session.BackendFS && to != session.BackendSQLite {
+				common.FailAndExit(1, fmt.Sprintf("Unknown storage backend: %s, expected %s or %s", to, session.BackendFS, session.BackendSQLite))
+			}
+			n := session.MigrateStorage(to)
+			fmt.Printf("Moved %d sessions to %s\n", n, to)
+		},
+	}.ToCobra()
+}
@@ -22,6 +22,7 @@ func Sync() *cobra.Command {
 		Args:   cobra.MinimumNArgs(0),
 		RunFunc: func(cmd *cobra.Command, args []string) {
 
+			requireFSStorage()
 			sessionsDir := session.Dir()
 
 			fmt.Printf("Syncing latest sessions %s <-> git remote\n", sessionsDir)
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/config"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/pricing"
+	"github.com/gigurra/ai/tokens"
+	"github.com/gigurra/ai/util"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+func Tokens() *cobra.Command {
+	var p struct {
+		Provider boa.Optional[string] `descr:"Provider to count tokens for (defaults to the configured one)" name:"provider"`
+		Model    boa.Optional[string] `descr:"Model to count tokens for (defaults to the configured one)" name:"model"`
+	}
+	return boa.Cmd{
+		Use:    "tokens",
+		Short:  "Count the tokens of stdin or files, offline",
+		Params: &p,
+		Args:   cobra.MinimumNArgs(0),
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			_, cfg := config.LoadCfgFile()
+			providerName := normalizeProviderName(p.Provider.GetOrElse(cfg.Provider))
+			model := p.Model.GetOrElse(cfg.Model(providerName))
+			counter := tokens.ForModel(providerName, model)
+
+			total := 0
+			if len(args) == 0 {
+				text, err := util.ReadAllStdIn()
+				if err != nil {
+					common.FailAndExit(1, fmt.Sprintf("Failed to read stdin: %v", err))
+				}
+				total = counter.Count(text)
+			} else {
+				for _, path := range args {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						common.FailAndExit(1, fmt.Sprintf("Failed to read %s: %v", path, err))
+					}
+					n := counter.Count(string(data))
+					total += n
+					fmt.Printf("%8d  %s\n", n, path)
+				}
+			}
+
+			fmt.Printf("%8d  total (%s, %s)\n", total, model, counter.Name())
+			if price, ok := pricing.Lookup(providerName, model, cfg.Pricing.Prices); ok {
+				fmt.Printf("%8s  as input\n", pricing.FormatUSD(price.Cost(total, 0, 0)))
+			}
+		},
+	}.ToCobra()
+}
+
+func normalizeProviderName(provider string) string {
+	return strings.ReplaceAll(strings.TrimSpace(provider), "_", "-")
+}
+
+// requestEstimate is what a request is expected to use, counted locally before sending it
+type requestEstimate struct {
+	Provider   string
+	Model      string
+	Tokens     int
+	Tokenizer  string
+	InputCost  float64 // USD, 0 if the price is unknown
+	PriceKnown bool
+}
+
+func estimateRequest(cfg config.Config, messages []domain.Message) requestEstimate {
+	providerName := normalizeProviderName(cfg.Provider)
+	model := cfg.Model(providerName)
+	counter := tokens.ForModel(providerName, model)
+	estimate := requestEstimate{
+		Provider:  providerName,
+		Model:     model,
+		Tokens:    tokens.CountMessages(counter, messages),
+		Tokenizer: counter.Name(),
+	}
+	if price, ok := cfg.Price(providerName); ok {
+		estimate.InputCost = price.Cost(estimate.Tokens, 0, 0)
+		estimate.PriceKnown = true
+	}
+	return estimate
+}
+
+// confirmRequestSize asks before sending more tokens than the configured threshold, if any.
+// The answer is read from the terminal, since stdin may be piped.
+func confirmRequestSize(cfg config.Config, estimate requestEstimate, skip bool) {
+	threshold := cfg.Preflight.ConfirmAboveTokens
+	if skip || threshold <= 0 || estimate.Tokens <= threshold {
+		return
+	}
+
+	cost := "unknown cost"
+	if estimate.PriceKnown {
+		cost = "about " + pricing.FormatUSD(estimate.InputCost) + " in input"
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "This request is ~%d tokens (%s, %s) to %s. Send it? (y/n) ", estimate.Tokens, estimate.Tokenizer, cost, estimate.Model)
+
+	answer, err := util.ReadTerminalLine()
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Request is above the confirmation threshold of %d tokens, and %v. Pass --yes to send it anyway.", threshold, err))
+	}
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		common.FailAndExit(1, "Aborted")
+	}
+}
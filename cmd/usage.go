@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/ledger"
+	"github.com/gigurra/ai/pricing"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+func Usage() *cobra.Command {
+	var p struct {
+		By     boa.Required[string] `descr:"Group by" default:"day" name:"by" alts:"day,week,provider,model,session"`
+		Since  boa.Optional[string] `descr:"Only include calls on or after this date (YYYY-MM-DD)" name:"since"`
+		Until  boa.Optional[string] `descr:"Only include calls on or before this date (YYYY-MM-DD)" name:"until"`
+		Format boa.Required[string] `descr:"Output format" default:"text" name:"format" short:"f" alts:"text,csv,json"`
+	}
+	return boa.Cmd{
+		Use:    "usage",
+		Short:  "Summarize provider calls and costs from the usage ledger",
+		Params: &p,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			since := parseDateFlag(p.Since, "since")
+			until := parseDateFlag(p.Until, "until")
+			if !until.IsZero() {
+				until = until.AddDate(0, 0, 1) // inclusive
+			}
+
+			entries, err := ledger.Read(since)
+			if err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+			if !until.IsZero() {
+				entries = lo.Filter(entries, func(e ledger.Entry, _ int) bool { return e.Time.Before(until) })
+			}
+
+			rows, err := ledger.Aggregate(entries, p.By.Value())
+			if err != nil {
+				common.FailAndExit(1, err.Error())
+			}
+
+			switch p.Format.Value() {
+			case "json":
+				bytes, err := json.MarshalIndent(rows, "", "  ")
+				if err != nil {
+					common.FailAndExit(1, fmt.Sprintf("Failed to marshal usage: %v", err))
+				}
+				fmt.Println(string(bytes))
+			case "csv":
+				w := csv.NewWriter(os.Stdout)
+				_ = w.Write([]string{p.By.Value(), "requests", "errors", "input_tokens", "cached_tokens", "output_tokens", "cost_usd", "avg_latency_ms"})
+				for _, row := range rows {
+					_ = w.Write([]string{
+						row.Key,
+						strconv.Itoa(row.Requests),
+						strconv.Itoa(row.Errors),
+						strconv.Itoa(row.InputTokens),
+						strconv.Itoa(row.CachedTokens),
+						strconv.Itoa(row.OutputTokens),
+						strconv.FormatFloat(row.Cost, 'f', 6, 64),
+						strconv.FormatInt(row.AvgLatencyMs, 10),
+					})
+				}
+				w.Flush()
+				if err := w.Error(); err != nil {
+					common.FailAndExit(1, fmt.Sprintf("Failed to write csv: %v", err))
+				}
+			case "text":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+				_, _ = fmt.Fprintf(w, "%s\trequests\terrors\tinput\tcached\toutput\tcost\tavg latency\t\n", p.By.Value())
+				for _, row := range append(rows, ledger.Total(entries)) {
+					_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%dms\t\n",
+						row.Key, row.Requests, row.Errors, row.InputTokens, row.CachedTokens, row.OutputTokens, pricing.FormatUSD(row.Cost), row.AvgLatencyMs)
+				}
+				_ = w.Flush()
+			default:
+				common.FailAndExit(1, fmt.Sprintf("Unknown format: %s", p.Format.Value()))
+			}
+		},
+	}.ToCobra()
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/pricing"
 	"github.com/gigurra/ai/providers/anthropic_provider"
+	"github.com/gigurra/ai/providers/azure_openai_provider"
 	"github.com/gigurra/ai/providers/google_ai_studio_provider"
 	"github.com/gigurra/ai/providers/google_cloud_provider"
+	"github.com/gigurra/ai/providers/ollama_provider"
 	"github.com/gigurra/ai/providers/openai_provider"
+	"github.com/gigurra/ai/providers/vertex_anthropic_provider"
 	"golang.org/x/term"
 	"gopkg.in/yaml.v3"
 	"io/fs"
@@ -29,6 +33,13 @@ type CliParams struct {
 	Model          boa.Optional[string]   `descr:"Model to use" name:"model"`
 	Temperature    boa.Optional[float64]  `descr:"Temperature to use" name:"temperature"`
 	ProviderApiKey boa.Optional[string]   `descr:"API key for provider" env:"PROVIDER_API_KEY"`
+	Schema         boa.Optional[string]   `descr:"JSON schema file. The answer is validated against it, and only the JSON is printed" name:"schema"`
+	SchemaRetries  boa.Required[int]      `descr:"Max automatic repair attempts when the answer doesn't match --schema" default:"2" name:"schema-retries"`
+	Rag            boa.Optional[string]   `descr:"Directory to retrieve context from (indexed with ai index)" name:"rag"`
+	RagTopK        boa.Required[int]      `descr:"Number of chunks to retrieve with --rag" default:"5" name:"rag-top-k"`
+	Yes            boa.Required[bool]     `descr:"Don't ask for confirmation before sending large requests" default:"false" name:"yes" short:"y"`
+	Force          boa.Required[bool]     `descr:"Send the request even if it exceeds a budget" default:"false" name:"force"`
+	WireLog        boa.Required[bool]     `descr:"Record raw requests and responses in the session dir (secrets redacted)" default:"false" name:"wire-log"`
 }
 
 type CliSubcParams struct {
@@ -39,17 +50,54 @@ type CliSubcParams struct {
 
 func (c *CliSubcParams) ToCliParams() *CliParams {
 	return &CliParams{
-		Session: c.Session,
-		Verbose: c.Verbose,
+		Session:  c.Session,
+		Verbose:  c.Verbose,
+		Provider: c.Provider,
 	}
 }
 
+type ContextConfig struct {
+	Strategy            string `yaml:"strategy,omitempty"`              // none, sliding_window, drop_attachments or summarize
+	ContextWindow       int    `yaml:"context_window,omitempty"`        // overrides the known context window of the model
+	ReserveOutputTokens int    `yaml:"reserve_output_tokens,omitempty"` // kept free for the answer, default 4096
+}
+
+type PreflightConfig struct {
+	ConfirmAboveTokens int `yaml:"confirm_above_tokens,omitempty"` // ask before sending more input tokens than this, 0 = never ask (default)
+}
+
+type PricingConfig struct {
+	Prices map[string]pricing.Price `yaml:"prices,omitempty"` // by model id prefix, overrides the built-in prices
+	Footer bool                     `yaml:"footer,omitempty"` // print tokens and cost after each answer
+}
+
+type BudgetLimits struct {
+	Daily   float64 `yaml:"daily,omitempty"`   // USD per calendar day, 0 = no limit
+	Monthly float64 `yaml:"monthly,omitempty"` // USD per calendar month, 0 = no limit
+}
+
+type BudgetConfig struct {
+	// the limits across all providers
+	BudgetLimits  `yaml:",inline"`
+	Providers     map[string]BudgetLimits `yaml:"providers,omitempty"`      // per provider section, e.g. openai
+	SessionTokens int                     `yaml:"session_tokens,omitempty"` // max input + output tokens per session, 0 = no limit
+}
+
 type StoredConfig struct {
-	Provider       string                           `yaml:"provider"`
-	OpenAI         openai_provider.Config           `yaml:"openai"`
-	GoogleCloud    google_cloud_provider.Config     `yaml:"google_cloud"`
-	GoogleAiStudio google_ai_studio_provider.Config `yaml:"google_ai_studio"`
-	Anthropic      anthropic_provider.Config        `yaml:"anthropic"`
+	Provider          string                           `yaml:"provider"`
+	EmbeddingProvider string                           `yaml:"embedding_provider,omitempty"` // used for search/rag, defaults to provider
+	OpenAI            openai_provider.Config           `yaml:"openai"`
+	GoogleCloud       google_cloud_provider.Config     `yaml:"google_cloud"`
+	GoogleAiStudio    google_ai_studio_provider.Config `yaml:"google_ai_studio"`
+	Anthropic         anthropic_provider.Config        `yaml:"anthropic"`
+	VertexAnthropic   vertex_anthropic_provider.Config `yaml:"vertex_anthropic"`
+	AzureOpenAI       azure_openai_provider.Config     `yaml:"azure_openai"`
+	Ollama            ollama_provider.Config           `yaml:"ollama"`
+	Context           ContextConfig                    `yaml:"context,omitempty"`
+	Preflight         PreflightConfig                  `yaml:"preflight,omitempty"`
+	Pricing           PricingConfig                    `yaml:"pricing,omitempty"`
+	Budget            BudgetConfig                     `yaml:"budget,omitempty"`
+	WireLog           bool                             `yaml:"wire_log,omitempty"` // record raw requests and responses, see ai debug last
 }
 
 func (s StoredConfig) Model(provider string) string {
@@ -65,11 +113,25 @@ func (s StoredConfig) Model(provider string) string {
 		return s.GoogleAiStudio.ModelId
 	case "anthropic":
 		return s.Anthropic.Model
+	case "vertex-anthropic":
+		return s.VertexAnthropic.Model
+	case "azure-openai":
+		return s.AzureOpenAI.Deployment
+	case "ollama":
+		return s.Ollama.Model
 	default:
 		return ""
 	}
 }
 
+// Price returns the price of the current model of provider, from the configured prices or the built-in ones
+func (s StoredConfig) Price(provider string) (pricing.Price, bool) {
+	if provider == "" {
+		provider = s.Provider
+	}
+	return pricing.Lookup(provider, s.Model(provider), s.Pricing.Prices)
+}
+
 type Config struct {
 	StoredConfig
 	Verbose bool
@@ -80,6 +142,8 @@ func (c Config) WithoutSecrets() Config {
 	c.GoogleCloud.ProjectID = "*****"
 	c.GoogleAiStudio.APIKey = "*****"
 	c.Anthropic.APIKey = "*****"
+	c.VertexAnthropic.ProjectID = "*****"
+	c.AzureOpenAI.APIKey = "*****"
 	return c
 }
 
@@ -159,11 +223,25 @@ func LoadCfgFile() (string, Config) {
 	}
 }
 
+// ValidateCfg applies the cli params to the config, and exits if the provider is not configured
 func ValidateCfg(
 	configFilePath string,
 	cfg Config,
 	p *CliParams,
 ) Config {
+	cfg, err := CheckCfg(configFilePath, cfg, p)
+	if err != nil {
+		common.FailAndExit(1, err.Error())
+	}
+	return cfg
+}
+
+// CheckCfg is ValidateCfg, returning an error instead of exiting
+func CheckCfg(
+	configFilePath string,
+	cfg Config,
+	p *CliParams,
+) (Config, error) {
 
 	if p.Provider.HasValue() {
 		cfg.Provider = *p.Provider.Value()
@@ -173,11 +251,15 @@ func ValidateCfg(
 		cfg.Verbose = true
 	}
 
+	if p.WireLog.HasValue() && p.WireLog.Value() {
+		cfg.WireLog = true
+	}
+
 	providerName := strings.ReplaceAll(strings.TrimSpace(cfg.Provider), "_", "-")
 
 	switch providerName {
 	case "":
-		common.FailAndExit(1, "No provider found in config file: "+configFilePath)
+		return cfg, errors.New("No provider found in config file: " + configFilePath)
 	case "openai":
 		if p.Temperature.HasValue() {
 			cfg.OpenAI.Temperature = *p.Temperature.Value()
@@ -189,30 +271,30 @@ func ValidateCfg(
 			cfg.OpenAI.APIKey = *p.ProviderApiKey.Value()
 		}
 		if cfg.OpenAI.APIKey == "" {
-			common.FailAndExit(1, "No openai api key found in config file: "+configFilePath)
+			return cfg, errors.New("No openai api key found in config file: " + configFilePath)
 		}
 	case "google-cloud":
 		if p.Model.HasValue() {
 			cfg.GoogleCloud.ModelId = *p.Model.Value()
 		}
 		if cfg.GoogleCloud.ProjectID == "" {
-			common.FailAndExit(1, "No google cloud project id found in config file: "+configFilePath)
+			return cfg, errors.New("No google cloud project id found in config file: " + configFilePath)
 		}
 		if cfg.GoogleCloud.LocationID == "" {
-			common.FailAndExit(1, "No google cloud location id found in config file: "+configFilePath)
+			return cfg, errors.New("No google cloud location id found in config file: " + configFilePath)
 		}
 		if cfg.GoogleCloud.ModelId == "" {
-			common.FailAndExit(1, "No google cloud model id found in config file: "+configFilePath)
+			return cfg, errors.New("No google cloud model id found in config file: " + configFilePath)
 		}
 	case "google-ai-studio":
 		if p.Model.HasValue() {
 			cfg.GoogleAiStudio.ModelId = *p.Model.Value()
 		}
 		if cfg.GoogleAiStudio.APIKey == "" {
-			common.FailAndExit(1, "No google ai studio api_key found in config file: "+configFilePath)
+			return cfg, errors.New("No google ai studio api_key found in config file: " + configFilePath)
 		}
 		if cfg.GoogleAiStudio.ModelId == "" {
-			common.FailAndExit(1, "No google ai studio model id found in config file: "+configFilePath)
+			return cfg, errors.New("No google ai studio model id found in config file: " + configFilePath)
 		}
 	case "anthropic":
 		if p.Model.HasValue() {
@@ -222,11 +304,50 @@ func ValidateCfg(
 			cfg.Anthropic.APIKey = *p.ProviderApiKey.Value()
 		}
 		if cfg.Anthropic.APIKey == "" {
-			common.FailAndExit(1, "No anthropic api key found in config file: "+configFilePath)
+			return cfg, errors.New("No anthropic api key found in config file: " + configFilePath)
+		}
+	case "vertex-anthropic":
+		if p.Model.HasValue() {
+			cfg.VertexAnthropic.Model = *p.Model.Value()
+		}
+		if cfg.VertexAnthropic.ProjectID == "" {
+			return cfg, errors.New("No vertex anthropic project id found in config file: " + configFilePath)
+		}
+		if cfg.VertexAnthropic.Model == "" {
+			return cfg, errors.New("No vertex anthropic model id found in config file: " + configFilePath)
+		}
+	case "azure-openai":
+		if p.Temperature.HasValue() {
+			cfg.AzureOpenAI.Temperature = *p.Temperature.Value()
+		}
+		if p.Model.HasValue() {
+			cfg.AzureOpenAI.Deployment = *p.Model.Value()
+		}
+		if p.ProviderApiKey.HasValue() {
+			cfg.AzureOpenAI.APIKey = *p.ProviderApiKey.Value()
+		}
+		if cfg.AzureOpenAI.Endpoint == "" {
+			return cfg, errors.New("No azure openai endpoint found in config file: " + configFilePath)
+		}
+		if cfg.AzureOpenAI.Deployment == "" {
+			return cfg, errors.New("No azure openai deployment found in config file: " + configFilePath)
+		}
+		if cfg.AzureOpenAI.APIKey == "" && cfg.AzureOpenAI.APIKeyCmd == "" {
+			return cfg, errors.New("No azure openai api_key or api_key_cmd found in config file: " + configFilePath)
+		}
+	case "ollama":
+		if p.Temperature.HasValue() {
+			cfg.Ollama.Temperature = *p.Temperature.Value()
+		}
+		if p.Model.HasValue() {
+			cfg.Ollama.Model = *p.Model.Value()
+		}
+		if cfg.Ollama.Model == "" {
+			return cfg, errors.New("No ollama model found in config file: " + configFilePath)
 		}
 	default:
-		common.FailAndExit(1, fmt.Sprintf("Unsupported provider: %s", providerName))
+		return cfg, fmt.Errorf("Unsupported provider: %s", providerName)
 	}
 
-	return cfg
+	return cfg, nil
 }
@@ -0,0 +1,208 @@
+package ctxwindow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/tokens"
+	"strings"
+)
+
+type Strategy string
+
+const (
+	None            Strategy = "none"
+	SlidingWindow   Strategy = "sliding_window"
+	DropAttachments Strategy = "drop_attachments"
+	Summarize       Strategy = "summarize"
+)
+
+var Strategies = []Strategy{None, SlidingWindow, DropAttachments, Summarize}
+
+// AttachmentMarker separates a question from data piped in on stdin
+const AttachmentMarker = "\n Attached additional info/data: \n"
+
+const attachmentRemovedNote = "\n[attached data removed to fit the context window]"
+
+const summaryNotePrefix = "[System note: the earlier part of this conversation was replaced with this summary of it.]\n\n"
+
+func ParseStrategy(s string) (Strategy, error) {
+	if s == "" {
+		return None, nil
+	}
+	for _, strategy := range Strategies {
+		if string(strategy) == s {
+			return strategy, nil
+		}
+	}
+	return None, fmt.Errorf("unknown context strategy: %s, expected one of %v", s, Strategies)
+}
+
+// Summary is a summary of the first Count messages of a conversation, with Hash
+// identifying those messages, so it can be reused as the conversation grows
+type Summary struct {
+	Count int    `json:"count"`
+	Hash  string `json:"hash"`
+	Text  string `json:"text"`
+}
+
+// Summarizer summarizes a conversation
+type Summarizer func(messages []domain.Message) (string, error)
+
+type Result struct {
+	Messages        []domain.Message
+	EstimatedBefore int
+	EstimatedAfter  int
+	Dropped         int      // messages left out entirely
+	Stripped        int      // messages whose attachments were removed
+	Summary         *Summary // set when the Summarize strategy was used
+}
+
+func (r Result) Changed() bool {
+	return r.Dropped > 0 || r.Stripped > 0 || r.Summary != nil
+}
+
+// Fit returns messages that fit within budget tokens, according to strategy. The last
+// message (the new question) is always kept. The input slice is never modified.
+// previous is an earlier summary to reuse, if it still covers a prefix of messages.
+func Fit(strategy Strategy, messages []domain.Message, budget int, previous *Summary, summarize Summarizer) (Result, error) {
+	result := Result{
+		Messages:        messages,
+		EstimatedBefore: tokens.EstimateMessages(messages),
+	}
+	result.EstimatedAfter = result.EstimatedBefore
+	if strategy == None || result.EstimatedBefore <= budget || len(messages) <= 1 {
+		return result, nil
+	}
+
+	switch strategy {
+	case SlidingWindow:
+		result.Messages, result.Dropped = slidingWindow(messages, budget)
+	case DropAttachments:
+		stripped, n := dropAttachments(messages, budget)
+		result.Stripped = n
+		result.Messages, result.Dropped = slidingWindow(stripped, budget)
+	case Summarize:
+		summarized, summary, err := summarizeOlder(messages, budget, previous, summarize)
+		if err != nil {
+			return result, err
+		}
+		result.Summary = summary
+		result.Messages, result.Dropped = slidingWindow(summarized, budget)
+	default:
+		return result, fmt.Errorf("unknown context strategy: %s", strategy)
+	}
+
+	result.EstimatedAfter = tokens.EstimateMessages(result.Messages)
+	return result, nil
+}
+
+// slidingWindow drops the oldest messages until the rest fit. Leading system messages are
+// kept, and the window never starts with an assistant message.
+func slidingWindow(messages []domain.Message, budget int) ([]domain.Message, int) {
+	head := 0
+	for head < len(messages)-1 && messages[head].SourceType == domain.System {
+		head++
+	}
+	pinned := messages[:head]
+	rest := messages[head:]
+
+	total := tokens.EstimateMessages(messages)
+	dropped := 0
+	for len(rest) > 1 && (total > budget || rest[0].SourceType == domain.Assistant) {
+		total -= tokens.EstimateMessages(rest[:1])
+		rest = rest[1:]
+		dropped++
+	}
+
+	return append(append([]domain.Message{}, pinned...), rest...), dropped
+}
+
+// dropAttachments removes stdin attachments from messages, oldest first, until they fit.
+// The attachment of the last message is kept.
+func dropAttachments(messages []domain.Message, budget int) ([]domain.Message, int) {
+	result := append([]domain.Message{}, messages...)
+	total := tokens.EstimateMessages(result)
+	stripped := 0
+	for i := 0; i < len(result)-1 && total > budget; i++ {
+		pos := strings.Index(result[i].Content, AttachmentMarker)
+		if pos < 0 {
+			continue
+		}
+		before := tokens.Estimate(result[i].Content)
+		result[i].Content = result[i].Content[:pos] + attachmentRemovedNote
+		total -= before - tokens.Estimate(result[i].Content)
+		stripped++
+	}
+	return result, stripped
+}
+
+// summarizeOlder replaces older messages with a summary note, keeping as many recent
+// messages as fit in half the budget. A previous summary is reused if it still fits,
+// or extended with the messages since.
+func summarizeOlder(messages []domain.Message, budget int, previous *Summary, summarize Summarizer) ([]domain.Message, *Summary, error) {
+	if previous != nil && (previous.Count >= len(messages) || previous.Hash != HashMessages(messages[:previous.Count])) {
+		previous = nil // the conversation it summarized is gone
+	}
+
+	if previous != nil {
+		withPrevious := append([]domain.Message{SummaryNote(previous.Text)}, messages[previous.Count:]...)
+		if tokens.EstimateMessages(withPrevious) <= budget {
+			return withPrevious, previous, nil
+		}
+	}
+
+	split := len(messages) - 1
+	recentTokens := tokens.EstimateMessages(messages[split:])
+	for split > 0 {
+		next := tokens.EstimateMessages(messages[split-1 : split])
+		if recentTokens+next > budget/2 {
+			break
+		}
+		recentTokens += next
+		split--
+	}
+	if split == 0 {
+		return messages, nil, nil // nothing old enough to summarize
+	}
+
+	toSummarize := messages[:split]
+	if previous != nil && previous.Count < split {
+		toSummarize = append([]domain.Message{SummaryNote(previous.Text)}, messages[previous.Count:split]...)
+	}
+	// the summary request itself must fit too
+	toSummarize, _ = slidingWindow(toSummarize, budget)
+
+	text, err := summarize(toSummarize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to summarize older messages: %w", err)
+	}
+
+	summary := &Summary{
+		Count: split,
+		Hash:  HashMessages(messages[:split]),
+		Text:  strings.TrimSpace(text),
+	}
+	return append([]domain.Message{SummaryNote(summary.Text)}, messages[split:]...), summary, nil
+}
+
+// SummaryNote is sent as a user message, since not all providers accept system messages mid-conversation
+func SummaryNote(summary string) domain.Message {
+	return domain.Message{
+		SourceType: domain.User,
+		Content:    summaryNotePrefix + summary,
+	}
+}
+
+func HashMessages(messages []domain.Message) string {
+	sb := strings.Builder{}
+	for _, message := range messages {
+		sb.WriteString(string(message.SourceType))
+		sb.WriteString("\x00")
+		sb.WriteString(message.Content)
+		sb.WriteString("\x00")
+	}
+	hash := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,108 @@
+package ctxwindow
+
+import (
+	"github.com/gigurra/ai/domain"
+	"strings"
+	"testing"
+)
+
+func conversation(turns int, wordsPerMessage int) []domain.Message {
+	text := strings.Repeat("word ", wordsPerMessage)
+	var messages []domain.Message
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			domain.Message{SourceType: domain.User, Content: text},
+			domain.Message{SourceType: domain.Assistant, Content: text},
+		)
+	}
+	return append(messages, domain.Message{SourceType: domain.User, Content: "the new question"})
+}
+
+func TestFitLeavesSmallConversationsAlone(t *testing.T) {
+	messages := conversation(2, 10)
+	res, err := Fit(SlidingWindow, messages, 10_000, nil, nil)
+	if err != nil {
+		t.Fatalf("Fit() failed: %v", err)
+	}
+	if res.Changed() || len(res.Messages) != len(messages) {
+		t.Errorf("expected no changes, got %+v", res)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	messages := append([]domain.Message{{SourceType: domain.System, Content: "be brief"}}, conversation(10, 100)...)
+
+	res, err := Fit(SlidingWindow, messages, 600, nil, nil)
+	if err != nil {
+		t.Fatalf("Fit() failed: %v", err)
+	}
+
+	if res.EstimatedAfter > 600 {
+		t.Errorf("expected the result to fit, estimated %d tokens", res.EstimatedAfter)
+	}
+	if res.Messages[0].SourceType != domain.System || res.Messages[1].SourceType != domain.User {
+		t.Errorf("expected the system message to be kept and the window to start with a user message, got %v, %v", res.Messages[0].SourceType, res.Messages[1].SourceType)
+	}
+	if last := res.Messages[len(res.Messages)-1]; last.Content != "the new question" {
+		t.Errorf("expected the new question to be kept, got %q", last.Content)
+	}
+	if messages[1].Content == "" || len(messages) != 22 {
+		t.Errorf("expected the input to be left untouched")
+	}
+}
+
+func TestDropAttachments(t *testing.T) {
+	messages := []domain.Message{
+		{SourceType: domain.User, Content: "what does this do?" + AttachmentMarker + strings.Repeat("code ", 1000)},
+		{SourceType: domain.Assistant, Content: "it prints hello"},
+		{SourceType: domain.User, Content: "and this?" + AttachmentMarker + "more code"},
+	}
+
+	res, err := Fit(DropAttachments, messages, 200, nil, nil)
+	if err != nil {
+		t.Fatalf("Fit() failed: %v", err)
+	}
+
+	if res.Stripped != 1 || res.Dropped != 0 || len(res.Messages) != 3 {
+		t.Fatalf("expected only the old attachment to be removed, got %+v", res)
+	}
+	if !strings.HasPrefix(res.Messages[0].Content, "what does this do?") || strings.Contains(res.Messages[0].Content, "code code") {
+		t.Errorf("unexpected stripped message: %q", res.Messages[0].Content)
+	}
+	if !strings.Contains(res.Messages[2].Content, "more code") {
+		t.Errorf("expected the attachment of the new question to be kept")
+	}
+}
+
+func TestSummarizeReusesPreviousSummary(t *testing.T) {
+	calls := 0
+	summarize := func(messages []domain.Message) (string, error) {
+		calls++
+		return "they talked about words", nil
+	}
+
+	messages := conversation(10, 100)
+	res, err := Fit(Summarize, messages, 1000, nil, summarize)
+	if err != nil {
+		t.Fatalf("Fit() failed: %v", err)
+	}
+	if calls != 1 || res.Summary == nil || res.Summary.Count == 0 {
+		t.Fatalf("expected a summary, got %+v after %d calls", res.Summary, calls)
+	}
+	if !strings.Contains(res.Messages[0].Content, "they talked about words") {
+		t.Errorf("expected the first message to be the summary, got %q", res.Messages[0].Content)
+	}
+	if res.EstimatedAfter > 1000 {
+		t.Errorf("expected the result to fit, estimated %d tokens", res.EstimatedAfter)
+	}
+
+	// one more short turn still fits next to the previous summary
+	messages = append(messages, domain.Message{SourceType: domain.Assistant, Content: "short answer"}, domain.Message{SourceType: domain.User, Content: "next"})
+	res2, err := Fit(Summarize, messages, 1000, res.Summary, summarize)
+	if err != nil {
+		t.Fatalf("Fit() failed: %v", err)
+	}
+	if calls != 1 || res2.Summary.Count != res.Summary.Count {
+		t.Errorf("expected the previous summary to be reused, got %d calls", calls)
+	}
+}
@@ -30,6 +30,8 @@ func (m Message) ToYaml() string {
 
 type Question struct {
 	Messages []Message
+	// ResponseSchema is an optional JSON schema the answer must be a JSON document of
+	ResponseSchema map[string]any
 }
 
 type RespChunk struct {
@@ -46,11 +48,32 @@ type Usage struct {
 	PromptTokens     int
 	CompletionTokens int
 	TotalTokens      int
+	CachedTokens     int // the part of PromptTokens read from the provider's prompt cache
+}
+
+// StopReason is the provider independent reason for why the model stopped generating
+type StopReason string
+
+const (
+	StopReasonUnknown       StopReason = ""
+	StopReasonEndTurn       StopReason = "end_turn"
+	StopReasonMaxTokens     StopReason = "max_tokens"
+	StopReasonStopSequence  StopReason = "stop_sequence"
+	StopReasonToolUse       StopReason = "tool_use"
+	StopReasonContentFilter StopReason = "content_filter"
+	StopReasonOther         StopReason = "other"
+)
+
+// IsTruncated is true when the answer was cut off before the model was done
+func (r StopReason) IsTruncated() bool {
+	return r == StopReasonMaxTokens
 }
 
 type Response interface {
 	GetChoices() []Choice
 	GetUsage() Usage
+	// GetStopReason is only set on the chunk where the provider reports it, StopReasonUnknown otherwise
+	GetStopReason() StopReason
 }
 
 type Provider interface {
@@ -60,3 +83,50 @@ type Provider interface {
 	BasicAsk(question Question) (Response, error)
 	BasicAskStream(question Question) <-chan RespChunk
 }
+
+// PrefillSupporter is implemented by providers that can continue generating from a trailing
+// assistant message (assistant prefill), instead of needing an instruction to continue
+type PrefillSupporter interface {
+	SupportsAssistantPrefill() bool
+}
+
+type Embeddings struct {
+	Model      string
+	Dimensions int
+	Vectors    [][]float32 // one per input text, in input order
+	Usage      Usage
+}
+
+// Embedder is implemented by providers that can turn texts into embedding vectors
+type Embedder interface {
+	Embed(texts []string) (Embeddings, error)
+	// MaxEmbedBatchSize is the max number of texts the provider accepts per Embed call
+	MaxEmbedBatchSize() int
+}
+
+// EmbedBatched embeds any number of texts, split into batches of at most batchSize
+// (or the provider max, if batchSize is 0 or larger than that)
+func EmbedBatched(embedder Embedder, texts []string, batchSize int) (Embeddings, error) {
+	if batchSize <= 0 || batchSize > embedder.MaxEmbedBatchSize() {
+		batchSize = embedder.MaxEmbedBatchSize()
+	}
+
+	res := Embeddings{}
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		batch, err := embedder.Embed(texts[start:end])
+		if err != nil {
+			return Embeddings{}, fmt.Errorf("failed to embed texts %d-%d: %w", start, end, err)
+		}
+		if len(batch.Vectors) != end-start {
+			return Embeddings{}, fmt.Errorf("expected %d embeddings, got %d", end-start, len(batch.Vectors))
+		}
+		res.Model = batch.Model
+		res.Dimensions = batch.Dimensions
+		res.Vectors = append(res.Vectors, batch.Vectors...)
+		res.Usage.PromptTokens += batch.Usage.PromptTokens
+		res.Usage.TotalTokens += batch.Usage.TotalTokens
+	}
+
+	return res, nil
+}
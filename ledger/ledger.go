@@ -0,0 +1,117 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	KindChat  = "chat"
+	KindEmbed = "embed"
+
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Entry is one provider call
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Kind         string    `json:"kind,omitempty"` // chat or embed
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Session      string    `json:"session,omitempty"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CachedTokens int       `json:"cached_tokens,omitempty"`
+	Cost         float64   `json:"cost"` // USD, 0 if the price of the model is unknown
+	LatencyMs    int64     `json:"latency_ms"`
+	Status       string    `json:"status,omitempty"` // ok or error
+	Error        string    `json:"error,omitempty"`
+	Host         string    `json:"host,omitempty"` // the machine the call was made from
+}
+
+func File() string {
+	return common.AppDir() + "/usage.jsonl"
+}
+
+// Append adds an entry to the ledger. Each entry is a single write to a file opened
+// for appending, so concurrent invocations don't interleave their lines.
+func Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger entry: %w", err)
+	}
+	file, err := os.OpenFile(File(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	return nil
+}
+
+// Read returns the entries at or after since. Lines that can't be parsed are skipped.
+func Read(since time.Time) ([]Entry, error) {
+	file, err := os.Open(File())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn(fmt.Sprintf("Skipping malformed line %d in %s: %v", lineNo, File(), err))
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return entries, nil
+}
+
+// Spent sums the cost of the entries at or after since, for provider, or for all providers if empty
+func Spent(entries []Entry, since time.Time, provider string) float64 {
+	total := 0.0
+	for _, entry := range entries {
+		if entry.Time.Before(since) || (provider != "" && entry.Provider != provider) {
+			continue
+		}
+		total += entry.Cost
+	}
+	return total
+}
+
+func StartOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func StartOfMonth(t time.Time) time.Time {
+	year, month, _ := t.Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+}
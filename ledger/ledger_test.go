@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	now := time.Now()
+	for _, entry := range []Entry{
+		{Time: now.Add(-48 * time.Hour), Provider: "openai", Cost: 1},
+		{Time: now, Provider: "openai", Cost: 0.5},
+		{Time: now, Provider: "anthropic", Cost: 0.25},
+	} {
+		if err := Append(entry); err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+	}
+	file, err := os.OpenFile(File(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("not json\n")
+	_ = file.Close()
+
+	entries, err := Read(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 recent entries, got %d", len(entries))
+	}
+	if spent := Spent(entries, now.Add(-time.Hour), ""); spent != 0.75 {
+		t.Errorf("expected 0.75 spent, got %v", spent)
+	}
+	if spent := Spent(entries, now.Add(-time.Hour), "openai"); spent != 0.5 {
+		t.Errorf("expected 0.5 spent on openai, got %v", spent)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	entries := []Entry{
+		{Time: day, Provider: "openai", Model: "gpt-4o", Session: "a", InputTokens: 10, Cost: 1, LatencyMs: 100, Status: StatusOK},
+		{Time: day.AddDate(0, 0, 1), Provider: "openai", Model: "gpt-4o", Session: "b", InputTokens: 20, Cost: 2, LatencyMs: 300, Status: StatusError},
+		{Time: day.AddDate(0, 0, 7), Provider: "anthropic", Model: "claude-sonnet-4", InputTokens: 5, Cost: 5, LatencyMs: 50, Status: StatusOK},
+	}
+
+	byWeek, err := Aggregate(entries, "week")
+	if err != nil {
+		t.Fatalf("Aggregate() failed: %v", err)
+	}
+	if len(byWeek) != 2 || byWeek[0].Key != "2025-W11" || byWeek[0].Requests != 2 || byWeek[0].Errors != 1 || byWeek[0].AvgLatencyMs != 200 {
+		t.Errorf("unexpected weekly usage: %+v", byWeek)
+	}
+
+	byModel, err := Aggregate(entries, "model")
+	if err != nil {
+		t.Fatalf("Aggregate() failed: %v", err)
+	}
+	if len(byModel) != 2 || byModel[0].Key != "anthropic/claude-sonnet-4" || byModel[1].InputTokens != 30 {
+		t.Errorf("expected models sorted by cost, got %+v", byModel)
+	}
+
+	bySession, _ := Aggregate(entries, "session")
+	if len(bySession) != 3 || bySession[0].Key != "-" {
+		t.Errorf("expected calls without a session to be grouped as -, got %+v", bySession)
+	}
+
+	if _, err := Aggregate(entries, "color"); err == nil {
+		t.Errorf("expected an error for an unknown grouping")
+	}
+}
@@ -0,0 +1,123 @@
+package ledger
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/pricing"
+	"log/slog"
+	"os"
+	"time"
+)
+
+type recorder struct {
+	template Entry
+	prices   map[string]pricing.Price
+}
+
+func newRecorder(template Entry, prices map[string]pricing.Price) recorder {
+	if template.Host == "" {
+		template.Host, _ = os.Hostname()
+	}
+	return recorder{template: template, prices: prices}
+}
+
+func (r recorder) record(kind string, model string, start time.Time, usage domain.Usage, err error) {
+	entry := r.template
+	entry.Time = start
+	entry.Kind = kind
+	if model != "" {
+		entry.Model = model
+	}
+	entry.InputTokens = usage.PromptTokens
+	entry.OutputTokens = usage.CompletionTokens
+	entry.CachedTokens = usage.CachedTokens
+	if price, ok := pricing.Lookup(entry.Provider, entry.Model, r.prices); ok {
+		entry.Cost = price.Cost(usage.PromptTokens, usage.CachedTokens, usage.CompletionTokens)
+	}
+	entry.LatencyMs = time.Since(start).Milliseconds()
+	entry.Status = StatusOK
+	if err != nil {
+		entry.Status = StatusError
+		entry.Error = err.Error()
+	}
+
+	if err := Append(entry); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to record usage: %v", err))
+	}
+}
+
+type recordingProvider struct {
+	domain.Provider
+	recorder
+}
+
+// Recording returns provider with every question asked through it recorded in the ledger.
+// template holds the fields known up front: provider, model, session.
+func Recording(provider domain.Provider, template Entry, prices map[string]pricing.Price) domain.Provider {
+	return recordingProvider{Provider: provider, recorder: newRecorder(template, prices)}
+}
+
+func (p recordingProvider) BasicAsk(question domain.Question) (domain.Response, error) {
+	start := time.Now()
+	res, err := p.Provider.BasicAsk(question)
+	usage := domain.Usage{}
+	if res != nil {
+		usage = res.GetUsage()
+	}
+	p.record(KindChat, "", start, usage, err)
+	return res, err
+}
+
+func (p recordingProvider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
+	start := time.Now()
+	in := p.Provider.BasicAskStream(question)
+	out := make(chan domain.RespChunk)
+	go func() {
+		defer close(out)
+		usage := domain.Usage{}
+		for chunk := range in {
+			if chunk.Err != nil {
+				// recorded before passing it on, since the receiver may exit on errors
+				p.record(KindChat, "", start, usage, chunk.Err)
+				out <- chunk
+				for range in {
+					// drain
+				}
+				return
+			}
+			if chunk.Resp != nil {
+				chunkUsage := chunk.Resp.GetUsage()
+				usage.PromptTokens += chunkUsage.PromptTokens
+				usage.CompletionTokens += chunkUsage.CompletionTokens
+				usage.TotalTokens += chunkUsage.TotalTokens
+				usage.CachedTokens += chunkUsage.CachedTokens
+			}
+			out <- chunk
+		}
+		p.record(KindChat, "", start, usage, nil)
+	}()
+	return out
+}
+
+// SupportsAssistantPrefill passes on the capability of the wrapped provider
+func (p recordingProvider) SupportsAssistantPrefill() bool {
+	prefill, ok := p.Provider.(domain.PrefillSupporter)
+	return ok && prefill.SupportsAssistantPrefill()
+}
+
+type recordingEmbedder struct {
+	domain.Embedder
+	recorder
+}
+
+// RecordingEmbedder returns embedder with every call recorded in the ledger
+func RecordingEmbedder(embedder domain.Embedder, template Entry, prices map[string]pricing.Price) domain.Embedder {
+	return recordingEmbedder{Embedder: embedder, recorder: newRecorder(template, prices)}
+}
+
+func (e recordingEmbedder) Embed(texts []string) (domain.Embeddings, error) {
+	start := time.Now()
+	res, err := e.Embedder.Embed(texts)
+	e.record(KindEmbed, res.Model, start, res.Usage, err)
+	return res, err
+}
@@ -0,0 +1,42 @@
+package ledger
+
+import (
+	"errors"
+	"github.com/gigurra/ai/domain"
+	"testing"
+	"time"
+)
+
+// failingProvider streams a single error chunk, like providers do for failed requests
+type failingProvider struct {
+	domain.Provider
+}
+
+func (failingProvider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
+	res := make(chan domain.RespChunk, 1)
+	res <- domain.RespChunk{Err: errors.New("unexpected status code: 529: overloaded")}
+	close(res)
+	return res
+}
+
+func TestRecordingStreamRecordsErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	provider := Recording(failingProvider{}, Entry{Provider: "anthropic", Model: "claude-sonnet-4", Session: "s1"}, nil)
+	var received []domain.RespChunk
+	for chunk := range provider.BasicAskStream(domain.Question{}) {
+		received = append(received, chunk)
+	}
+	if len(received) != 1 || received[0].Err == nil {
+		t.Fatalf("expected the error chunk to be passed on, got %+v", received)
+	}
+
+	entries, err := Read(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Status != StatusError || entries[0].Error != "unexpected status code: 529: overloaded" ||
+		entries[0].Kind != KindChat || entries[0].Provider != "anthropic" || entries[0].Session != "s1" {
+		t.Errorf("expected one error entry, got %+v", entries)
+	}
+}
@@ -0,0 +1,93 @@
+package ledger
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var GroupBys = []string{"day", "week", "provider", "model", "session"}
+
+// Row is the usage of one group of entries
+type Row struct {
+	Key          string  `json:"key"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	InputTokens  int     `json:"input_tokens"`
+	CachedTokens int     `json:"cached_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+}
+
+func (r *Row) add(entry Entry) {
+	r.AvgLatencyMs = (r.AvgLatencyMs*int64(r.Requests) + entry.LatencyMs) / int64(r.Requests+1)
+	r.Requests++
+	if entry.Status == StatusError {
+		r.Errors++
+	}
+	r.InputTokens += entry.InputTokens
+	r.CachedTokens += entry.CachedTokens
+	r.OutputTokens += entry.OutputTokens
+	r.Cost += entry.Cost
+}
+
+// Aggregate groups entries by day, week (ISO), provider, model or session. Days and weeks are
+// in local time and sorted in order, the other groupings are sorted by cost, highest first.
+func Aggregate(entries []Entry, by string) ([]Row, error) {
+	var keyOf func(Entry) string
+	switch by {
+	case "day":
+		keyOf = func(e Entry) string { return e.Time.Local().Format(time.DateOnly) }
+	case "week":
+		keyOf = func(e Entry) string {
+			year, week := e.Time.Local().ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}
+	case "provider":
+		keyOf = func(e Entry) string { return e.Provider }
+	case "model":
+		keyOf = func(e Entry) string { return e.Provider + "/" + e.Model }
+	case "session":
+		keyOf = func(e Entry) string { return e.Session }
+	default:
+		return nil, fmt.Errorf("can't group by %s, expected one of %v", by, GroupBys)
+	}
+
+	rows := map[string]*Row{}
+	for _, entry := range entries {
+		key := keyOf(entry)
+		if key == "" {
+			key = "-"
+		}
+		if rows[key] == nil {
+			rows[key] = &Row{Key: key}
+		}
+		rows[key].add(entry)
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	slices.SortFunc(result, func(a, b Row) int {
+		if by == "day" || by == "week" || a.Cost == b.Cost {
+			return strings.Compare(a.Key, b.Key)
+		}
+		if a.Cost > b.Cost {
+			return -1
+		}
+		return 1
+	})
+	return result, nil
+}
+
+// Total sums all entries into one row
+func Total(entries []Entry) Row {
+	total := Row{Key: "total"}
+	for _, entry := range entries {
+		total.add(entry)
+	}
+	return total
+}
@@ -35,6 +35,19 @@ func main() {
 			cmd.Pull(),
 			cmd.Push(),
 			cmd.Sync(),
+			cmd.Models(),
+			cmd.Continue(),
+			cmd.Embed(),
+			cmd.Search(),
+			cmd.Grep(),
+			cmd.Index(),
+			cmd.ContextStrategy(),
+			cmd.Compact(),
+			cmd.Tokens(),
+			cmd.Usage(),
+			cmd.Debug(),
+			cmd.Migrate(),
+			cmd.Storage(),
 		},
 		RunFunc: cmd.Default(cliParams),
 	}.Run()
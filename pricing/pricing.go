@@ -0,0 +1,95 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Price is in USD per million tokens
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+	Cached float64 `yaml:"cached,omitempty"` // input tokens read from the prompt cache, charged as Input if 0
+}
+
+// defaults maps model id prefixes to list prices. The longest matching prefix wins.
+var defaults = map[string]Price{
+	"gpt-3.5-turbo":          {Input: 0.50, Output: 1.50},
+	"gpt-4":                  {Input: 30.00, Output: 60.00},
+	"gpt-4-turbo":            {Input: 10.00, Output: 30.00},
+	"gpt-4o":                 {Input: 2.50, Output: 10.00, Cached: 1.25},
+	"gpt-4o-mini":            {Input: 0.15, Output: 0.60, Cached: 0.075},
+	"gpt-4.1":                {Input: 2.00, Output: 8.00, Cached: 0.50},
+	"gpt-4.1-mini":           {Input: 0.40, Output: 1.60, Cached: 0.10},
+	"gpt-4.1-nano":           {Input: 0.10, Output: 0.40, Cached: 0.025},
+	"gpt-5":                  {Input: 1.25, Output: 10.00, Cached: 0.125},
+	"gpt-5-mini":             {Input: 0.25, Output: 2.00, Cached: 0.025},
+	"gpt-5-nano":             {Input: 0.05, Output: 0.40, Cached: 0.005},
+	"o1":                     {Input: 15.00, Output: 60.00, Cached: 7.50},
+	"o3":                     {Input: 2.00, Output: 8.00, Cached: 0.50},
+	"o4-mini":                {Input: 1.10, Output: 4.40, Cached: 0.275},
+	"claude-3-haiku":         {Input: 0.25, Output: 1.25, Cached: 0.03},
+	"claude-3-opus":          {Input: 15.00, Output: 75.00, Cached: 1.50},
+	"claude-3-5-haiku":       {Input: 0.80, Output: 4.00, Cached: 0.08},
+	"claude-3-5-sonnet":      {Input: 3.00, Output: 15.00, Cached: 0.30},
+	"claude-3-7-sonnet":      {Input: 3.00, Output: 15.00, Cached: 0.30},
+	"claude-sonnet-4":        {Input: 3.00, Output: 15.00, Cached: 0.30},
+	"claude-opus-4":          {Input: 15.00, Output: 75.00, Cached: 1.50},
+	"gemini-1.5-flash":       {Input: 0.075, Output: 0.30, Cached: 0.01875},
+	"gemini-1.5-pro":         {Input: 1.25, Output: 5.00, Cached: 0.3125},
+	"gemini-2.0-flash":       {Input: 0.10, Output: 0.40, Cached: 0.025},
+	"gemini-2.0-flash-lite":  {Input: 0.075, Output: 0.30},
+	"gemini-2.5-flash":       {Input: 0.30, Output: 2.50, Cached: 0.075},
+	"gemini-2.5-pro":         {Input: 1.25, Output: 10.00, Cached: 0.31},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+	"text-embedding-ada-002": {Input: 0.10},
+	"gemini-embedding-001":   {Input: 0.15},
+}
+
+// Lookup returns the price of a model. Prices configured by the user, keyed by model id
+// prefix, take precedence over the built-in defaults. Local models (ollama) are free
+// unless configured otherwise.
+func Lookup(provider string, model string, configured map[string]Price) (Price, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if price, ok := longestPrefixMatch(model, configured); ok {
+		return price, true
+	}
+	if provider == "ollama" {
+		return Price{}, true
+	}
+	return longestPrefixMatch(model, defaults)
+}
+
+func longestPrefixMatch(model string, prices map[string]Price) (Price, bool) {
+	best := ""
+	found := false
+	for prefix := range prices {
+		if strings.HasPrefix(model, strings.ToLower(prefix)) && (!found || len(prefix) > len(best)) {
+			best = prefix
+			found = true
+		}
+	}
+	if !found {
+		return Price{}, false
+	}
+	return prices[best], true
+}
+
+// Cost returns the cost in USD of the given token counts. cachedTokens is the part of
+// inputTokens that was read from the prompt cache.
+func (p Price) Cost(inputTokens int, cachedTokens int, outputTokens int) float64 {
+	cachedPrice := p.Cached
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	cachedTokens = min(cachedTokens, inputTokens)
+	return (float64(inputTokens-cachedTokens)*p.Input + float64(cachedTokens)*cachedPrice + float64(outputTokens)*p.Output) / 1_000_000
+}
+
+func FormatUSD(amount float64) string {
+	if amount > 0 && amount < 0.01 {
+		return fmt.Sprintf("$%.4f", amount)
+	}
+	return fmt.Sprintf("$%.2f", amount)
+}
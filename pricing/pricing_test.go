@@ -0,0 +1,39 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	price, ok := Lookup("openai", "gpt-4o-mini-2024-07-18", nil)
+	if !ok || price.Input != 0.15 {
+		t.Errorf("expected the gpt-4o-mini price, got %+v, %v", price, ok)
+	}
+
+	configured := map[string]Price{"gpt-4o": {Input: 1, Output: 2}}
+	price, ok = Lookup("openai", "gpt-4o-mini", configured)
+	if !ok || price.Input != 1 {
+		t.Errorf("expected the configured price to take precedence, got %+v", price)
+	}
+
+	if _, ok := Lookup("anthropic", "some-future-model", nil); ok {
+		t.Errorf("expected no price for an unknown model")
+	}
+	if price, ok := Lookup("ollama", "llama3", nil); !ok || price != (Price{}) {
+		t.Errorf("expected ollama to be free, got %+v, %v", price, ok)
+	}
+}
+
+func TestCost(t *testing.T) {
+	price := Price{Input: 2, Output: 10, Cached: 0.5}
+	cost := price.Cost(1_000_000, 400_000, 100_000)
+	if math.Abs(cost-(1.2+0.2+1)) > 1e-9 {
+		t.Errorf("unexpected cost: %v", cost)
+	}
+
+	uncachedPrice := Price{Input: 2, Output: 10}
+	if cost := uncachedPrice.Cost(1_000_000, 400_000, 0); math.Abs(cost-2) > 1e-9 {
+		t.Errorf("expected cached tokens to cost the input price when no cached price is set, got %v", cost)
+	}
+}
@@ -31,18 +31,63 @@ type Message struct {
 	Content string `json:"content"`
 }
 
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
 type RequestBody struct {
-	Model     string    `json:"model"`
-	Messages  []Message `json:"messages"`
-	MaxTokens *int      `json:"max_tokens,omitempty"`
-	Stream    bool      `json:"stream"`
+	AnthropicVersion string      `json:"anthropic_version,omitempty"` // only used on vertex ai, where the model is part of the url
+	Model            string      `json:"model,omitempty"`
+	Messages         []Message   `json:"messages"`
+	MaxTokens        *int        `json:"max_tokens,omitempty"`
+	Stream           bool        `json:"stream"`
+	Tools            []Tool      `json:"tools,omitempty"`
+	ToolChoice       *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+// structuredOutputTool is the tool we force the model to call when asking for structured output.
+// Its input is the answer.
+const structuredOutputTool = "respond"
+
+func NewRequestBody(model string, question domain.Question, maxTokens int) RequestBody {
+	body := RequestBody{
+		Model: model,
+		Messages: lo.Map(question.Messages, func(message domain.Message, index int) Message {
+			return Message{
+				Role:    string(message.SourceType),
+				Content: message.Content,
+			}
+		}),
+		MaxTokens: &maxTokens,
+		Stream:    true,
+	}
+	if question.ResponseSchema != nil {
+		body.Tools = []Tool{{
+			Name:        structuredOutputTool,
+			Description: "Respond to the user with a JSON document matching the input schema",
+			InputSchema: question.ResponseSchema,
+		}}
+		body.ToolChoice = &ToolChoice{
+			Type: "tool",
+			Name: structuredOutputTool,
+		}
+	}
+	return body
 }
 
 // see https://docs.anthropic.com/en/api/messages-streaming#basic-streaming-request
 
 type BasicAskResponse struct {
-	Choices []domain.Choice
-	Usage   domain.Usage
+	Choices    []domain.Choice
+	Usage      domain.Usage
+	StopReason domain.StopReason
 }
 
 var _ domain.Response = &BasicAskResponse{}
@@ -55,24 +100,57 @@ func (r *BasicAskResponse) GetUsage() domain.Usage {
 	return r.Usage
 }
 
+func (r *BasicAskResponse) GetStopReason() domain.StopReason {
+	return r.StopReason
+}
+
+func StopReasonToDomain(stopReason string) domain.StopReason {
+	switch stopReason {
+	case "end_turn":
+		return domain.StopReasonEndTurn
+	case "max_tokens":
+		return domain.StopReasonMaxTokens
+	case "stop_sequence":
+		return domain.StopReasonStopSequence
+	case "tool_use":
+		return domain.StopReasonToolUse
+	case "refusal":
+		return domain.StopReasonContentFilter
+	case "":
+		return domain.StopReasonUnknown
+	default:
+		return domain.StopReasonOther
+	}
+}
+
 func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
+	return CollectStream(o.BasicAskStream(question))
+}
 
-	stream := o.BasicAskStream(question)
+// CollectStream accumulates a streamed response into a single response
+func CollectStream(stream <-chan domain.RespChunk) (domain.Response, error) {
 
 	accum := strings.Builder{}
 
 	promptTokens := 0
 	completionTokens := 0
 	totalTokens := 0
+	cachedTokens := 0
+	stopReason := domain.StopReasonUnknown
 
 	for chunk := range stream {
 		if chunk.Err != nil {
 			return nil, chunk.Err
 		}
 
+		if chunk.Resp.GetStopReason() != domain.StopReasonUnknown {
+			stopReason = chunk.Resp.GetStopReason()
+		}
+
 		promptTokens += chunk.Resp.GetUsage().PromptTokens
 		completionTokens += chunk.Resp.GetUsage().CompletionTokens
 		totalTokens += chunk.Resp.GetUsage().TotalTokens
+		cachedTokens += chunk.Resp.GetUsage().CachedTokens
 
 		if len(chunk.Resp.GetChoices()) == 0 {
 			continue // this is the final chunk
@@ -94,13 +172,17 @@ func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
 			PromptTokens:     promptTokens,
 			CompletionTokens: completionTokens,
 			TotalTokens:      totalTokens,
+			CachedTokens:     cachedTokens,
 		},
+		StopReason: stopReason,
 	}, nil
 }
 
 type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Name        string `json:"name,omitempty"`         // for tool_use blocks
+	PartialJson string `json:"partial_json,omitempty"` // for input_json_delta deltas
 }
 
 type ContentBlockStart struct {
@@ -116,8 +198,9 @@ type ContentBlockDelta struct {
 }
 
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
 }
 
 type MessageStartMessage struct {
@@ -137,8 +220,8 @@ type MessageStart struct {
 }
 
 type MessageDeltaDelta struct {
-	StopReason   any `json:"stop_reason"`
-	StopSequence any `json:"stop_sequence"`
+	StopReason   string `json:"stop_reason"`
+	StopSequence any    `json:"stop_sequence"`
 }
 
 type MessageDelta struct {
@@ -148,8 +231,6 @@ type MessageDelta struct {
 }
 
 func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
-	resChan := make(chan domain.RespChunk, 1024)
-
 	host := "api.anthropic.com"
 	u, err := url.Parse("https://" + host + "/v1/messages")
 	if err != nil {
@@ -172,17 +253,7 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 		common.FailAndExit(1, "Anthropic max_output_tokens configuration parameter is required")
 	}
 
-	body := RequestBody{
-		Model: o.cfg.Model,
-		Messages: lo.Map(question.Messages, func(message domain.Message, index int) Message {
-			return Message{
-				Role:    string(message.SourceType),
-				Content: message.Content,
-			}
-		}),
-		MaxTokens: &o.cfg.MaxOutputTokens,
-		Stream:    true,
-	}
+	body := NewRequestBody(o.cfg.Model, question, o.cfg.MaxOutputTokens)
 
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
@@ -204,10 +275,19 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 		Host:          host,
 	}
 
-	res, err := http.DefaultClient.Do(&request)
+	return StreamRequest(&request)
+}
+
+// StreamRequest sends a streaming messages api request and parses the SSE events of the response.
+// Shared with providers that serve anthropic models behind other endpoints, e.g. vertex ai.
+func StreamRequest(request *http.Request) <-chan domain.RespChunk {
+	resChan := make(chan domain.RespChunk, 1024)
+
+	res, err := http.DefaultClient.Do(request)
 	if err != nil {
-		respBody, _ := io.ReadAll(res.Body)
-		common.FailAndExit(1, fmt.Sprintf("Failed to do request: %v: %s", err, string(respBody)))
+		resChan <- domain.RespChunk{Err: fmt.Errorf("failed to do request: %w", err)}
+		close(resChan)
+		return resChan
 	}
 
 	closeBody := func() {
@@ -220,7 +300,9 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 	if res.StatusCode != 200 {
 		defer closeBody()
 		respBody, _ := io.ReadAll(res.Body)
-		common.FailAndExit(1, fmt.Sprintf("Failed to do request, unexpected status code: %v: %s", res.StatusCode, string(respBody)))
+		resChan <- domain.RespChunk{Err: fmt.Errorf("failed to do request, unexpected status code: %v: %s", res.StatusCode, string(respBody))}
+		close(resChan)
+		return resChan
 	}
 
 	go func() {
@@ -230,7 +312,10 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 
 		accumInputTokens := 0
 		accumOutputTokens := 0
+		accumCachedTokens := 0
+		stopReason := domain.StopReasonUnknown
 		isInsideTextContentBlock := false
+		isInsideToolUseContentBlock := false
 
 		stream := sse_parser.NewParser(isValidJsonObject).Stream(res.Body, 100)
 		for msg := range stream {
@@ -248,7 +333,9 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 					}
 					return
 				}
-				accumInputTokens += messageStart.Message.Usage.InputTokens
+				// input_tokens doesn't include cache reads, but domain.Usage.PromptTokens does
+				accumInputTokens += messageStart.Message.Usage.InputTokens + messageStart.Message.Usage.CacheReadInputTokens
+				accumCachedTokens += messageStart.Message.Usage.CacheReadInputTokens
 				// apparently we shouldn't count these output tokens, to stay consistent with
 				// anthropic's own token counting (see https://console.anthropic.com/settings/logs)
 				//accumOutputTokens += messageStart.Message.Usage.OutputTokens
@@ -264,8 +351,12 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 				if contentBlockStart.ContentBlock.Type == "text" {
 					isInsideTextContentBlock = true
 				}
+				// forced tool use for structured output, the tool input is the answer
+				if contentBlockStart.ContentBlock.Type == "tool_use" && contentBlockStart.ContentBlock.Name == structuredOutputTool {
+					isInsideToolUseContentBlock = true
+				}
 			case "content_block_delta":
-				if isInsideTextContentBlock {
+				if isInsideTextContentBlock || isInsideToolUseContentBlock {
 					var contentBlockDelta ContentBlockDelta
 					err := json.Unmarshal([]byte(dataStr), &contentBlockDelta)
 					if err != nil {
@@ -274,6 +365,10 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 						}
 						return
 					}
+					content := contentBlockDelta.Delta.Text
+					if isInsideToolUseContentBlock {
+						content = contentBlockDelta.Delta.PartialJson
+					}
 					resChan <- domain.RespChunk{
 						Resp: &BasicAskResponse{
 							Choices: []domain.Choice{
@@ -281,7 +376,7 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 									Index: 0,
 									Message: domain.Message{
 										SourceType: domain.Assistant,
-										Content:    contentBlockDelta.Delta.Text,
+										Content:    content,
 									},
 								},
 							},
@@ -293,6 +388,7 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 				}
 			case "content_block_stop":
 				isInsideTextContentBlock = false
+				isInsideToolUseContentBlock = false
 			case "message_stop":
 				// we're done!
 				resChan <- domain.RespChunk{
@@ -302,7 +398,9 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 							PromptTokens:     accumInputTokens,
 							CompletionTokens: accumOutputTokens,
 							TotalTokens:      accumInputTokens + accumOutputTokens,
+							CachedTokens:     accumCachedTokens,
 						},
+						StopReason: stopReason,
 					},
 				}
 			case "message_delta":
@@ -316,6 +414,9 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 				}
 				accumInputTokens += messageDelta.Usage.InputTokens
 				accumOutputTokens += messageDelta.Usage.OutputTokens
+				if messageDelta.Delta.StopReason != "" {
+					stopReason = StopReasonToDomain(messageDelta.Delta.StopReason)
+				}
 			default:
 				// do nothing, unsupported (by our ai) events
 			}
@@ -328,6 +429,12 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 // prove that OpenAIProvider implements the Provider interface
 var _ domain.Provider = &Provider{}
 
+var _ domain.PrefillSupporter = &Provider{}
+
+func (o Provider) SupportsAssistantPrefill() bool {
+	return true
+}
+
 func NewAnthropicProvider(cfg Config, verbose bool) *Provider {
 
 	provider := &Provider{
@@ -1,7 +1,11 @@
 package anthropic_provider
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/gigurra/ai/domain"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -23,3 +27,61 @@ func TestSanitizeLines(t *testing.T) {
 	}
 
 }
+
+func TestNewRequestBodyForcesRespondToolForSchemas(t *testing.T) {
+	schema := map[string]any{"type": "object", "properties": map[string]any{"name": map[string]any{"type": "string"}}}
+	question := domain.Question{
+		Messages:       []domain.Message{{SourceType: domain.User, Content: "hi"}},
+		ResponseSchema: schema,
+	}
+
+	body := NewRequestBody("claude-sonnet-4", question, 1024)
+	if len(body.Tools) != 1 || body.Tools[0].Name != structuredOutputTool || body.Tools[0].InputSchema["type"] != "object" {
+		t.Errorf("expected the respond tool with the schema as input, got %+v", body.Tools)
+	}
+	if body.ToolChoice == nil || body.ToolChoice.Type != "tool" || body.ToolChoice.Name != structuredOutputTool {
+		t.Errorf("expected the respond tool to be forced, got %+v", body.ToolChoice)
+	}
+
+	bytes, err := json.Marshal(NewRequestBody("claude-sonnet-4", domain.Question{Messages: question.Messages}, 1024))
+	if err != nil {
+		t.Fatalf("failed to marshal request body: %v", err)
+	}
+	if strings.Contains(string(bytes), "tool") {
+		t.Errorf("expected no tools without a schema, got %s", bytes)
+	}
+}
+
+func TestStreamRequestReassemblesToolInput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []struct{ name, data string }{
+			{"message_start", `{"type":"message_start","message":{"usage":{"input_tokens":12}}}`},
+			{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","name":"respond"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"name\":"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":" \"Ada\"}"}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+			{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`},
+			{"message_stop", `{"type":"message_stop"}`},
+		} {
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+		}
+	}))
+	defer server.Close()
+
+	request, _ := http.NewRequest("POST", server.URL+"/v1/messages", strings.NewReader("{}"))
+	resp, err := CollectStream(StreamRequest(request))
+	if err != nil {
+		t.Fatalf("CollectStream() failed: %v", err)
+	}
+
+	if got := resp.GetChoices()[0].Message.Content; got != `{"name": "Ada"}` {
+		t.Errorf("content = %q; want the reassembled tool input", got)
+	}
+	if got := resp.GetStopReason(); got != domain.StopReasonToolUse {
+		t.Errorf("stop reason = %q; want %q", got, domain.StopReasonToolUse)
+	}
+	if got := resp.GetUsage(); got.PromptTokens != 12 || got.CompletionTokens != 5 {
+		t.Errorf("usage = %+v; want 12 prompt and 5 completion tokens", got)
+	}
+}
@@ -0,0 +1,184 @@
+package azure_openai_provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/GiGurra/cmder"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/providers/openai_provider"
+	"github.com/gigurra/ai/providers/token_cache"
+	"github.com/gigurra/ai/util"
+	"github.com/samber/lo"
+	"github.com/sashabaranov/go-openai"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultApiVersion = "2024-10-21"
+
+// legacyDeploymentsApiVersion is tried for listing deployments when the configured api version
+// doesn't support it. Newer data plane versions dropped the deployments api.
+const legacyDeploymentsApiVersion = "2022-12-01"
+
+// defaultTokenLifetime is used when the token from api_key_cmd is not a JWT we can read the expiry from
+const defaultTokenLifetime = 30 * time.Minute
+
+type Config struct {
+	Endpoint            string  `yaml:"endpoint"` // e.g. https://my-resource.openai.azure.com
+	Deployment          string  `yaml:"deployment"`
+	EmbeddingDeployment string  `yaml:"embedding_deployment"`
+	ApiVersion          string  `yaml:"api_version"`
+	APIKey              string  `yaml:"api_key"`     // sent as api-key header
+	APIKeyCmd           string  `yaml:"api_key_cmd"` // alternative to api_key, the output is sent as a bearer token
+	Temperature         float64 `yaml:"temperature"`
+}
+
+type Provider struct {
+	*openai_provider.Provider
+	cfg         Config
+	authHeaders map[string]string
+}
+
+// prove that Provider implements the Provider interface
+var _ domain.Provider = &Provider{}
+
+var _ domain.Embedder = &Provider{}
+
+// Embed embeds with the embedding_deployment. Without one, the request would be
+// routed to the chat deployment, which can't embed
+func (o Provider) Embed(texts []string) (domain.Embeddings, error) {
+	if o.cfg.EmbeddingDeployment == "" {
+		return domain.Embeddings{}, fmt.Errorf("no embedding_deployment configured for azure openai")
+	}
+	return o.Provider.Embed(texts)
+}
+
+func NewAzureOpenAIProvider(cfg Config, verbose bool) (*Provider, error) {
+
+	authToken := cfg.APIKey
+	authHeaders := map[string]string{"api-key": cfg.APIKey}
+	if cfg.APIKeyCmd != "" {
+		token, err := token_cache.GetOrRefresh(TokenCacheKey(cfg), func() (token_cache.Token, error) {
+			return runApiKeyCmd(cfg.APIKeyCmd)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get azure openai token from api_key_cmd: %w", err)
+		}
+		authToken = token.AccessToken
+		authHeaders = map[string]string{"Authorization": "Bearer " + token.AccessToken}
+	}
+
+	clientCfg := openai.DefaultAzureConfig(authToken, strings.TrimRight(cfg.Endpoint, "/"))
+	if cfg.APIKeyCmd != "" {
+		clientCfg.APIType = openai.APITypeAzureAD
+	}
+	clientCfg.APIVersion = cfg.apiVersion()
+	// we always address deployments directly, so model names are deployment names
+	clientCfg.AzureModelMapperFunc = func(model string) string {
+		if cfg.EmbeddingDeployment != "" && model == cfg.EmbeddingDeployment {
+			return cfg.EmbeddingDeployment
+		}
+		return cfg.Deployment
+	}
+
+	provider := &Provider{
+		Provider: openai_provider.NewOpenAIProviderWithClientConfig(openai_provider.Config{
+			Model:          cfg.Deployment,
+			EmbeddingModel: cfg.EmbeddingDeployment,
+			Temperature:    cfg.Temperature,
+		}, clientCfg),
+		cfg:         cfg,
+		authHeaders: authHeaders,
+	}
+
+	if verbose {
+		deployments, err := provider.ListModels()
+		if err != nil {
+			slog.Info(fmt.Sprintf("Could not check the deployment, listing azure openai deployments failed: %v", err))
+		} else if !lo.Contains(deployments, cfg.Deployment) {
+			slog.Warn(fmt.Sprintf("Deployment '%s' not found among: %v", cfg.Deployment, deployments))
+		}
+	}
+
+	return provider, nil
+}
+
+func (c Config) apiVersion() string {
+	if c.ApiVersion == "" {
+		return DefaultApiVersion
+	}
+	return c.ApiVersion
+}
+
+// TokenCacheKey identifies the cached bearer token for an endpoint and api_key_cmd
+func TokenCacheKey(cfg Config) string {
+	return fmt.Sprintf("azure-openai/%s/%s", strings.TrimRight(cfg.Endpoint, "/"), cfg.APIKeyCmd)
+}
+
+func runApiKeyCmd(apiKeyCmd string) (token_cache.Token, error) {
+	shell, shellArg := "sh", "-c"
+	if util.IsWindows() {
+		shell, shellArg = "cmd", "/C"
+	}
+	res := cmder.New(shell, shellArg, apiKeyCmd).Run(context.Background())
+	if res.Err != nil {
+		return token_cache.Token{}, fmt.Errorf("'%s' failed: %w: %s", apiKeyCmd, res.Err, res.Combined)
+	}
+
+	accessToken := strings.TrimSpace(res.StdOut)
+	if accessToken == "" {
+		return token_cache.Token{}, fmt.Errorf("'%s' returned an empty token", apiKeyCmd)
+	}
+
+	expiresAt, ok := token_cache.JwtExpiry(accessToken)
+	if !ok {
+		expiresAt = time.Now().Add(defaultTokenLifetime)
+	}
+
+	return token_cache.Token{
+		AccessToken: accessToken,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+type deployment struct {
+	ID     string `json:"id"`
+	Model  string `json:"model"`
+	Status string `json:"status"`
+}
+
+type deploymentList struct {
+	Data []deployment `json:"data"`
+}
+
+// ListModels lists the deployments of the azure openai resource, since that is what we address.
+// The configured api version is tried first, then the last one known to list deployments.
+func (o Provider) ListModels() ([]string, error) {
+	versions := lo.Uniq([]string{o.cfg.apiVersion(), legacyDeploymentsApiVersion})
+	var res deploymentList
+	var err error
+	for _, version := range versions {
+		res, err = util.HttpGetRecvJson[deploymentList](
+			strings.TrimRight(o.cfg.Endpoint, "/")+"/openai/deployments",
+			util.GetParams{
+				QueryParams: map[string]string{"api-version": version},
+				Headers:     o.authHeaders,
+			},
+		)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments with api-version %s: %w", strings.Join(versions, " or "), err)
+	}
+
+	deployments := lo.Map(res.Data, func(item deployment, _ int) string {
+		return item.ID
+	})
+	sort.Strings(deployments)
+
+	return deployments, nil
+}
@@ -0,0 +1,117 @@
+package azure_openai_provider
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/providers/token_cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const chatCompletion = `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`
+
+func TestBasicAskWithApiKey(t *testing.T) {
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, chatCompletion)
+	}))
+	defer server.Close()
+
+	provider, err := NewAzureOpenAIProvider(Config{Endpoint: server.URL + "/", Deployment: "my-gpt-4o", APIKey: "secret"}, false)
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIProvider() failed: %v", err)
+	}
+	resp, err := provider.BasicAsk(domain.Question{
+		Messages: []domain.Message{{SourceType: domain.User, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("BasicAsk() failed: %v", err)
+	}
+
+	if got := resp.GetChoices()[0].Message.Content; got != "Hello" {
+		t.Errorf("content = %q; want %q", got, "Hello")
+	}
+	if received.URL.Path != "/openai/deployments/my-gpt-4o/chat/completions" || received.URL.Query().Get("api-version") != DefaultApiVersion {
+		t.Errorf("unexpected url: %s", received.URL)
+	}
+	if received.Header.Get("api-key") != "secret" || received.Header.Get("Authorization") != "" {
+		t.Errorf("expected the api key in the api-key header, got %v", received.Header)
+	}
+}
+
+func TestBasicAskWithApiKeyCmdToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, chatCompletion)
+	}))
+	defer server.Close()
+
+	// a cached token, so api_key_cmd isn't run
+	cfg := Config{Endpoint: server.URL, Deployment: "my-gpt-4o", ApiVersion: "2025-01-01-preview", APIKeyCmd: "get-token"}
+	err := token_cache.Store(token_cache.Token{Key: TokenCacheKey(cfg), AccessToken: "cmd-token", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to cache token: %v", err)
+	}
+
+	provider, err := NewAzureOpenAIProvider(cfg, false)
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIProvider() failed: %v", err)
+	}
+	_, err = provider.BasicAsk(domain.Question{
+		Messages: []domain.Message{{SourceType: domain.User, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("BasicAsk() failed: %v", err)
+	}
+
+	if received.URL.Path != "/openai/deployments/my-gpt-4o/chat/completions" || received.URL.Query().Get("api-version") != "2025-01-01-preview" {
+		t.Errorf("unexpected url: %s", received.URL)
+	}
+	if received.Header.Get("Authorization") != "Bearer cmd-token" || received.Header.Get("api-key") != "" {
+		t.Errorf("expected the token as a bearer token, got %v", received.Header)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	var triedVersions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("api-key") != "secret" {
+			t.Errorf("expected the api key to be sent, got %v", r.Header)
+		}
+		version := r.URL.Query().Get("api-version")
+		triedVersions = append(triedVersions, version)
+		if version != legacyDeploymentsApiVersion {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"data":[{"id":"my-gpt-4o","model":"gpt-4o","status":"succeeded"},{"id":"my-embeddings","model":"text-embedding-3-small","status":"succeeded"}]}`)
+	}))
+	defer server.Close()
+
+	provider, err := NewAzureOpenAIProvider(Config{Endpoint: server.URL, Deployment: "my-gpt-4o", APIKey: "secret"}, false)
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIProvider() failed: %v", err)
+	}
+	deployments, err := provider.ListModels()
+	if err != nil {
+		t.Fatalf("ListModels() failed: %v", err)
+	}
+
+	if len(deployments) != 2 || deployments[0] != "my-embeddings" || deployments[1] != "my-gpt-4o" {
+		t.Errorf("deployments = %v; want them sorted by id", deployments)
+	}
+	if len(triedVersions) != 2 || triedVersions[0] != DefaultApiVersion || triedVersions[1] != legacyDeploymentsApiVersion {
+		t.Errorf("expected the configured api version to be tried first, got %v", triedVersions)
+	}
+}
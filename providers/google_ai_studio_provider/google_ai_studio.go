@@ -5,18 +5,23 @@ import (
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/domain"
 	"github.com/gigurra/ai/providers/google_common"
+	"github.com/gigurra/ai/util"
+	"github.com/samber/lo"
 	"net/url"
-	"strings"
 )
 
+const DefaultEmbeddingModel = "gemini-embedding-001"
+
 type Config struct {
-	APIKey          string  `yaml:"api_key"`
-	ModelId         string  `yaml:"model_id"`
-	MaxOutputTokens int     `yaml:"max_output_tokens"`
-	Temperature     float64 `yaml:"temperature"`
-	TopP            float64 `yaml:"top_p"`
-	TopK            float64 `yaml:"top_k"`
-	Verbose         bool    `yaml:"verbose"`
+	APIKey          string            `yaml:"api_key"`
+	ModelId         string            `yaml:"model_id"`
+	EmbeddingModel  string            `yaml:"embedding_model"`
+	MaxOutputTokens int               `yaml:"max_output_tokens"`
+	Temperature     float64           `yaml:"temperature"`
+	TopP            float64           `yaml:"top_p"`
+	TopK            float64           `yaml:"top_k"`
+	SafetySettings  map[string]string `yaml:"safety_settings"`
+	Verbose         bool              `yaml:"verbose"`
 }
 
 func (c Config) WithVerbose(verbose bool) Config {
@@ -29,30 +34,7 @@ type Provider struct {
 }
 
 func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
-	stream := o.BasicAskStream(question)
-	acc := strings.Builder{}
-	for respChunk := range stream {
-		if respChunk.Err != nil {
-			return nil, respChunk.Err
-		}
-		cs := respChunk.Resp.GetChoices()
-		if len(cs) != 1 {
-			return nil, fmt.Errorf("expected exactly one choice")
-		}
-		acc.WriteString(cs[0].Message.Content)
-	}
-
-	return &google_common.RespImpl{
-		Choices: []domain.Choice{
-			{
-				Index: 0,
-				Message: domain.Message{
-					SourceType: domain.System,
-					Content:    acc.String(),
-				},
-			},
-		},
-	}, nil
+	return google_common.CollectStream(o.BasicAskStream(question))
 }
 
 func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
@@ -75,6 +57,7 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 		Temperature:     o.cfg.Temperature,
 		TopP:            o.cfg.TopP,
 		TopK:            o.cfg.TopK,
+		SafetySettings:  o.cfg.SafetySettings,
 		Verbose:         o.cfg.Verbose,
 	}
 
@@ -89,6 +72,86 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 // prove that OpenAIProvider implements the Provider interface
 var _ domain.Provider = &Provider{}
 
+var _ domain.Embedder = &Provider{}
+
+type EmbedContentRequest struct {
+	Model   string                `json:"model"`
+	Content google_common.Content `json:"content"`
+}
+
+type BatchEmbedContentsRequest struct {
+	Requests []EmbedContentRequest `json:"requests"`
+}
+
+type ContentEmbedding struct {
+	Values []float32 `json:"values"`
+}
+
+type EmbedContentResponse struct {
+	Embedding ContentEmbedding `json:"embedding"`
+}
+
+type BatchEmbedContentsResponse struct {
+	Embeddings []ContentEmbedding `json:"embeddings"`
+}
+
+func (o Provider) MaxEmbedBatchSize() int {
+	return 100
+}
+
+// Embed uses embedContent for single texts and batchEmbedContents for several
+func (o Provider) Embed(texts []string) (domain.Embeddings, error) {
+	model := o.cfg.EmbeddingModel
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+
+	requests := lo.Map(texts, func(text string, _ int) EmbedContentRequest {
+		return EmbedContentRequest{
+			Model:   "models/" + model,
+			Content: google_common.Content{Parts: []google_common.Part{{Text: text}}},
+		}
+	})
+
+	params := util.PostParams{
+		QueryParams: map[string]string{"key": o.cfg.APIKey},
+	}
+
+	var vectors [][]float32
+	if len(requests) == 1 {
+		params.Body = requests[0]
+		res, err := util.HttpPostRecvJson[EmbedContentResponse](o.embedUrl(model, "embedContent"), params)
+		if err != nil {
+			return domain.Embeddings{}, fmt.Errorf("failed to embed content: %w", err)
+		}
+		vectors = [][]float32{res.Embedding.Values}
+	} else {
+		params.Body = BatchEmbedContentsRequest{Requests: requests}
+		res, err := util.HttpPostRecvJson[BatchEmbedContentsResponse](o.embedUrl(model, "batchEmbedContents"), params)
+		if err != nil {
+			return domain.Embeddings{}, fmt.Errorf("failed to batch embed contents: %w", err)
+		}
+		vectors = lo.Map(res.Embeddings, func(embedding ContentEmbedding, _ int) []float32 {
+			return embedding.Values
+		})
+	}
+
+	dimensions := 0
+	if len(vectors) > 0 {
+		dimensions = len(vectors[0])
+	}
+
+	return domain.Embeddings{
+		Model:      model,
+		Dimensions: dimensions,
+		Vectors:    vectors,
+	}, nil
+}
+
+func (o Provider) embedUrl(model string, method string) string {
+	return fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:%s", model, method)
+}
+
 func NewGoogleAiStudioProvider(cfg Config, verbose bool) *Provider {
 	return &Provider{
 		cfg: cfg.WithVerbose(verbose),
@@ -2,24 +2,31 @@ package google_cloud_provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/GiGurra/cmder"
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/domain"
 	"github.com/gigurra/ai/providers/google_common"
+	"github.com/gigurra/ai/providers/token_cache"
+	"github.com/gigurra/ai/util"
+	"log/slog"
 	"net/url"
+	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	ProjectID       string  `yaml:"project_id"`
-	LocationID      string  `yaml:"location_id"`
-	ModelId         string  `yaml:"model_id"`
-	MaxOutputTokens int     `yaml:"max_output_tokens"`
-	Temperature     float64 `yaml:"temperature"`
-	TopP            float64 `yaml:"top_p"`
-	TopK            float64 `yaml:"top_k"`
-	Verbose         bool    `yaml:"verbose"`
+	ProjectID       string            `yaml:"project_id"`
+	LocationID      string            `yaml:"location_id"`
+	ModelId         string            `yaml:"model_id"`
+	MaxOutputTokens int               `yaml:"max_output_tokens"`
+	Temperature     float64           `yaml:"temperature"`
+	TopP            float64           `yaml:"top_p"`
+	TopK            float64           `yaml:"top_k"`
+	SafetySettings  map[string]string `yaml:"safety_settings"`
+	Verbose         bool              `yaml:"verbose"`
 }
 
 func (c Config) WithVerbose(verbose bool) Config {
@@ -33,91 +40,188 @@ type Provider struct {
 }
 
 func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
-	stream := o.BasicAskStream(question)
-	acc := strings.Builder{}
-	for respChunk := range stream {
-		if respChunk.Err != nil {
-			return nil, respChunk.Err
-		}
-		cs := respChunk.Resp.GetChoices()
-		if len(cs) != 1 {
-			return nil, fmt.Errorf("expected exactly one choice")
-		}
-		acc.WriteString(cs[0].Message.Content)
-	}
-
-	return &google_common.RespImpl{
-		Choices: []domain.Choice{
-			{
-				Index: 0,
-				Message: domain.Message{
-					SourceType: domain.System,
-					Content:    acc.String(),
-				},
-			},
-		},
-	}, nil
+	return google_common.CollectStream(o.BasicAskStream(question))
 }
 
 func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
 
+	endpointUrl := PublisherModelUrl(o.cfg.ProjectID, o.cfg.LocationID, "google", o.cfg.ModelId, "streamGenerateContent")
+
+	cfg := &google_common.Config{
+		ModelId:         o.cfg.ModelId,
+		MaxOutputTokens: o.cfg.MaxOutputTokens,
+		Temperature:     o.cfg.Temperature,
+		TopP:            o.cfg.TopP,
+		TopK:            o.cfg.TopK,
+		SafetySettings:  o.cfg.SafetySettings,
+		Verbose:         o.cfg.Verbose,
+	}
+
+	authHeader := fmt.Sprintf("Bearer %s", o.accessToken)
+
+	return google_common.BasicAskStream(
+		endpointUrl,
+		authHeader,
+		cfg,
+		question,
+	)
+}
+
+// PublisherModelUrl builds a Vertex AI url for calling a method on a publisher model,
+// e.g. publisher "google" and method "streamGenerateContent"
+func PublisherModelUrl(projectID string, locationID string, publisher string, modelID string, method string) *url.URL {
 	location := func() string {
-		if o.cfg.LocationID == "" || o.cfg.LocationID == "global" {
+		if locationID == "" || locationID == "global" {
 			return "global"
 		} else {
-			return o.cfg.LocationID
+			return locationID
 		}
 	}()
 	host := func() string {
 		if location == "global" {
 			return "aiplatform.googleapis.com"
 		} else {
-			return fmt.Sprintf("%s-aiplatform.googleapis.com", o.cfg.LocationID)
+			return fmt.Sprintf("%s-aiplatform.googleapis.com", location)
 		}
 	}()
 	baseUrl := fmt.Sprintf("https://%s", host)
 	endpointUrl, err := url.Parse(
 		fmt.Sprintf(
-			"%s/v1/projects/%s/locations/%s/publishers/google/models/%s:streamGenerateContent",
-			baseUrl, o.cfg.ProjectID, location, o.cfg.ModelId,
+			"%s/v1/projects/%s/locations/%s/publishers/%s/models/%s:%s",
+			baseUrl, projectID, location, publisher, modelID, method,
 		),
 	)
 	if err != nil {
 		common.FailAndExit(1, fmt.Sprintf("failed to parse URL: %v", err))
 	}
+	return endpointUrl
+}
 
-	cfg := &google_common.Config{
-		ModelId:         o.cfg.ModelId,
-		MaxOutputTokens: o.cfg.MaxOutputTokens,
-		Temperature:     o.cfg.Temperature,
-		TopP:            o.cfg.TopP,
-		TopK:            o.cfg.TopK,
-		Verbose:         o.cfg.Verbose,
+// prove that OpenAIProvider implements the Provider interface
+var _ domain.Provider = &Provider{}
+
+func NewGoogleCloudProvider(cfg Config, Verbose bool) (*Provider, error) {
+	accessToken, err := AccessToken(cfg.ProjectID)
+	if err != nil {
+		return nil, err
 	}
+	return &Provider{
+		cfg:         cfg.WithVerbose(Verbose),
+		accessToken: accessToken,
+	}, nil
+}
 
-	authHeader := fmt.Sprintf("Bearer %s", o.accessToken)
+// TokenCacheKey identifies the cached gcloud access token for the active gcloud account and project
+func TokenCacheKey(projectID string) string {
+	account := activeGcloudAccount()
+	if account == "" {
+		account = "unknown-account"
+	}
+	return fmt.Sprintf("google-cloud/%s/%s", account, projectID)
+}
 
-	return google_common.BasicAskStream(
-		endpointUrl,
-		authHeader,
-		cfg,
-		question,
-	)
+// AccessToken returns a cached gcloud access token, only calling out to gcloud when
+// the cached one is missing or about to expire
+func AccessToken(projectID string) (string, error) {
+	token, err := token_cache.GetOrRefresh(TokenCacheKey(projectID), fetchGcloudAccessToken)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get access token with gcloud. Check if you are logged in: %w", err)
+	}
+	return token.AccessToken, nil
 }
 
-// prove that OpenAIProvider implements the Provider interface
-var _ domain.Provider = &Provider{}
+type gcloudConfigHelperOutput struct {
+	Credential struct {
+		AccessToken string `json:"access_token"`
+		TokenExpiry string `json:"token_expiry"`
+	} `json:"credential"`
+}
+
+// defaultTokenLifetime is used if gcloud doesn't tell us when the token expires. gcloud tokens
+// normally live for an hour.
+const defaultTokenLifetime = 30 * time.Minute
 
-func NewGoogleCloudProvider(cfg Config, Verbose bool) *Provider {
-	// get access token. TODO: Use a library instead to lower the dependency on gcloud and latencies
-	res := cmder.New("gcloud", "auth", "print-access-token").Run(context.Background())
+func fetchGcloudAccessToken() (token_cache.Token, error) {
+	// TODO: Use a library instead to lower the dependency on gcloud
+	res := cmder.New("gcloud", "config", "config-helper", "--format=json").Run(context.Background())
 	if res.Err != nil {
-		common.FailAndExit(1, "Failed to get access token with gcloud. Check if you are logged in.")
+		return token_cache.Token{}, fmt.Errorf("gcloud config config-helper failed: %w", res.Err)
 	}
-	return &Provider{
-		cfg:         cfg.WithVerbose(Verbose),
-		accessToken: strings.TrimSpace(res.StdOut),
+
+	var output gcloudConfigHelperOutput
+	err := json.Unmarshal([]byte(res.StdOut), &output)
+	if err != nil {
+		return token_cache.Token{}, fmt.Errorf("failed to parse gcloud config-helper output: %w", err)
+	}
+
+	if output.Credential.AccessToken == "" {
+		return token_cache.Token{}, fmt.Errorf("no access token in gcloud config-helper output")
 	}
+
+	expiresAt, err := time.Parse(time.RFC3339, output.Credential.TokenExpiry)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Could not parse gcloud token expiry '%s', assuming %v", output.Credential.TokenExpiry, defaultTokenLifetime))
+		expiresAt = time.Now().Add(defaultTokenLifetime)
+	}
+
+	return token_cache.Token{
+		AccessToken: strings.TrimSpace(output.Credential.AccessToken),
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+func gcloudConfigDir() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return dir
+	}
+	if util.IsWindows() {
+		return os.Getenv("APPDATA") + "/gcloud"
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return homeDir + "/.config/gcloud"
+}
+
+// activeGcloudAccount reads the active account straight from gcloud's config files,
+// since asking gcloud itself would cost the subprocess call we are trying to avoid
+func activeGcloudAccount() string {
+	if account := os.Getenv("CLOUDSDK_CORE_ACCOUNT"); account != "" {
+		return account
+	}
+
+	configDir := gcloudConfigDir()
+	configName := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if configName == "" {
+		activeConfigBytes, err := os.ReadFile(configDir + "/active_config")
+		if err == nil {
+			configName = strings.TrimSpace(string(activeConfigBytes))
+		}
+	}
+	if configName == "" {
+		configName = "default"
+	}
+
+	configBytes, err := os.ReadFile(configDir + "/configurations/config_" + configName)
+	if err != nil {
+		return ""
+	}
+
+	section := ""
+	for _, line := range strings.Split(string(configBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if section == "core" && found && strings.TrimSpace(key) == "account" {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
 }
 
 func (o Provider) ListModels() ([]string, error) {
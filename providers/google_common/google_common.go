@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/bcicen/jstream"
+	"github.com/GiGurra/sse-parser"
 	"github.com/gigurra/ai/common"
 	"github.com/gigurra/ai/domain"
 	"github.com/samber/lo"
@@ -12,15 +12,18 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 )
 
 type Config struct {
-	ModelId         string  `yaml:"model_id"`
-	MaxOutputTokens int     `yaml:"max_output_tokens"`
-	Temperature     float64 `yaml:"temperature"`
-	TopP            float64 `yaml:"top_p"`
-	TopK            float64 `yaml:"top_k"`
-	Verbose         bool    `yaml:"verbose"`
+	ModelId         string            `yaml:"model_id"`
+	MaxOutputTokens int               `yaml:"max_output_tokens"`
+	Temperature     float64           `yaml:"temperature"`
+	TopP            float64           `yaml:"top_p"`
+	TopK            float64           `yaml:"top_k"`
+	SafetySettings  map[string]string `yaml:"safety_settings"`
+	Verbose         bool              `yaml:"verbose"`
 }
 
 type Content struct {
@@ -33,10 +36,12 @@ type Part struct {
 }
 
 type GenerationConfig struct {
-	MaxOutputTokens int     `json:"maxOutputTokens"`
-	Temperature     float64 `json:"temperature"`
-	TopP            float64 `json:"topP"`
-	TopK            float64 `json:"topK"`
+	MaxOutputTokens  int            `json:"maxOutputTokens"`
+	Temperature      float64        `json:"temperature"`
+	TopP             float64        `json:"topP"`
+	TopK             float64        `json:"topK"`
+	ResponseMimeType string         `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]any `json:"responseSchema,omitempty"`
 }
 
 type RequestData struct {
@@ -51,14 +56,22 @@ type SafetySetting struct {
 }
 
 type ContentResponse struct {
-	Candidates    []Candidate   `json:"candidates"`
-	UsageMetadata UsageMetadata `json:"usageMetadata,omitempty"`
+	Candidates     []Candidate     `json:"candidates"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *UsageMetadata  `json:"usageMetadata,omitempty"`
+}
+
+type PromptFeedback struct {
+	BlockReason        string         `json:"blockReason,omitempty"`
+	BlockReasonMessage string         `json:"blockReasonMessage,omitempty"`
+	SafetyRatings      []SafetyRating `json:"safetyRatings,omitempty"`
 }
 
 type UsageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount"`
-	CandidatesTokenCount int `json:"candidatesTokenCount"`
-	TotalTokenCount      int `json:"totalTokenCount"`
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
 }
 
 type SafetyRating struct {
@@ -67,6 +80,7 @@ type SafetyRating struct {
 	ProbabilityScore float64 `json:"probabilityScore"`
 	Severity         string  `json:"severity"`
 	SeverityScore    float64 `json:"severityScore"`
+	Blocked          bool    `json:"blocked,omitempty"`
 }
 
 type Candidate struct {
@@ -75,6 +89,93 @@ type Candidate struct {
 	FinishReason  string         `json:"finishReason,omitempty"`
 }
 
+// BlockedError is returned when gemini refuses to answer, either because the prompt
+// was blocked or because generation was stopped for e.g. safety or recitation reasons
+type BlockedError struct {
+	Reason        string
+	Message       string
+	SafetyRatings []SafetyRating
+}
+
+func (e BlockedError) Error() string {
+	msg := fmt.Sprintf("response blocked by gemini, reason: %s", e.Reason)
+	if e.Message != "" {
+		msg += fmt.Sprintf(" (%s)", e.Message)
+	}
+	if ratings := e.CausingRatings(); len(ratings) > 0 {
+		msg += ", safety ratings: " + strings.Join(lo.Map(ratings, func(r SafetyRating, _ int) string {
+			return r.String()
+		}), ", ")
+	}
+	return msg
+}
+
+// CausingRatings returns the safety ratings that caused the block. Gemini only sometimes
+// flags the blocking rating explicitly, otherwise we report all non-negligible ones.
+func (e BlockedError) CausingRatings() []SafetyRating {
+	blocked := lo.Filter(e.SafetyRatings, func(r SafetyRating, _ int) bool {
+		return r.Blocked
+	})
+	if len(blocked) > 0 {
+		return blocked
+	}
+	return lo.Filter(e.SafetyRatings, func(r SafetyRating, _ int) bool {
+		return r.Probability != "" && r.Probability != "NEGLIGIBLE"
+	})
+}
+
+func (r SafetyRating) String() string {
+	res := fmt.Sprintf("%s=%s", r.Category, r.Probability)
+	if r.Severity != "" {
+		res += fmt.Sprintf("/%s", r.Severity)
+	}
+	if r.Blocked {
+		res += " (blocked)"
+	}
+	return res
+}
+
+// SafetySettingsFromConfig converts configured category -> threshold pairs to request safety settings.
+// Categories may be given without the HARM_CATEGORY_ prefix, e.g. dangerous_content: block_only_high
+func SafetySettingsFromConfig(cfg map[string]string) []SafetySetting {
+	res := make([]SafetySetting, 0, len(cfg))
+	for category, threshold := range cfg {
+		category = strings.ToUpper(strings.TrimSpace(category))
+		if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+			category = "HARM_CATEGORY_" + category
+		}
+		res = append(res, SafetySetting{
+			Category:  category,
+			Threshold: strings.ToUpper(strings.TrimSpace(threshold)),
+		})
+	}
+	slices.SortFunc(res, func(a, b SafetySetting) int {
+		return strings.Compare(a.Category, b.Category)
+	})
+	return res
+}
+
+// NoCandidatesError is returned for response chunks with neither candidates, usage nor block reason
+type NoCandidatesError struct {
+	RawData string
+}
+
+func (e NoCandidatesError) Error() string {
+	return fmt.Sprintf("no candidates in gemini response: %s", e.RawData)
+}
+
+// blockingFinishReasons are the finish reasons meaning gemini stopped generating on purpose
+// for other reasons than being done or running out of tokens
+var blockingFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"LANGUAGE":           true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
 func BasicAskStream(
 	endpointUrl *url.URL,
 	authHeader string,
@@ -101,33 +202,23 @@ func BasicAskStream(
 				TopP:            common.CfgOrDefaultF(cfg.TopP, 1.0),
 				TopK:            common.CfgOrDefaultF(cfg.TopK, 40.0),
 			},
-			//SafetySettings: []SafetySetting{
-			//	{
-			//		Category:  "HARM_CATEGORY_HATE_SPEECH",
-			//		Threshold: "BLOCK_MEDIUM_AND_ABOVE",
-			//	},
-			//	{
-			//		Category:  "HARM_CATEGORY_DANGEROUS_CONTENT",
-			//		Threshold: "BLOCK_MEDIUM_AND_ABOVE",
-			//	},
-			//	{
-			//		Category:  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-			//		Threshold: "BLOCK_MEDIUM_AND_ABOVE",
-			//	},
-			//	{
-			//		Category:  "HARM_CATEGORY_HARASSMENT",
-			//		Threshold: "BLOCK_MEDIUM_AND_ABOVE",
-			//	},
-			//},
+			SafetySettings: SafetySettingsFromConfig(cfg.SafetySettings),
+		}
+
+		if question.ResponseSchema != nil {
+			bodyT.GenerationConfig.ResponseMimeType = "application/json"
+			bodyT.GenerationConfig.ResponseSchema = ToGeminiSchema(question.ResponseSchema)
 		}
 
 		if cfg.Verbose {
 			slog.Info(fmt.Sprintf("GenerationConfig: %+v", bodyT.GenerationConfig))
+			slog.Info(fmt.Sprintf("SafetySettings: %+v", bodyT.SafetySettings))
 		}
 
 		bodyBytes, err := json.Marshal(bodyT)
 		if err != nil {
-			panic(fmt.Sprintf("Failed to marshal body: %v", err))
+			respChan <- domain.RespChunk{Err: fmt.Errorf("failed to marshal body: %w", err)}
+			return
 		}
 		bodyReadCloser := io.NopCloser(bytes.NewReader(bodyBytes))
 
@@ -140,7 +231,7 @@ func BasicAskStream(
 
 		request := http.Request{
 			Method:        "POST",
-			URL:           endpointUrl,
+			URL:           withSseQueryParam(endpointUrl),
 			Header:        headers,
 			Body:          bodyReadCloser,
 			ContentLength: int64(len(bodyBytes)),
@@ -149,8 +240,8 @@ func BasicAskStream(
 
 		res, err := http.DefaultClient.Do(&request)
 		if err != nil {
-			respBody, _ := io.ReadAll(res.Body)
-			panic(fmt.Sprintf("Failed to do request: %v: %s", err, string(respBody)))
+			respChan <- domain.RespChunk{Err: fmt.Errorf("failed to do request: %w", err)}
+			return
 		}
 		defer func() {
 			err := res.Body.Close()
@@ -161,41 +252,32 @@ func BasicAskStream(
 
 		if res.StatusCode != 200 {
 			respBody, _ := io.ReadAll(res.Body)
-			panic(fmt.Sprintf("Failed to do request, unexpected status code: %v: %s", res.StatusCode, string(respBody)))
+			respChan <- domain.RespChunk{Err: fmt.Errorf("failed to do request, unexpected status code: %v: %s", res.StatusCode, string(respBody))}
+			return
 		}
 
-		decoder := jstream.NewDecoder(res.Body, 1)
-		for mv := range decoder.Stream() {
-			jsonRepr, _ := json.Marshal(mv.Value)
+		// gemini reports the accumulated usage in every chunk, so we only pass on the last one
+		var usage *UsageMetadata
+
+		stream := sse_parser.NewParser(isValidJsonObject).Stream(res.Body, 100)
+		for msg := range stream {
 			if cfg.Verbose {
-				slog.Info(fmt.Sprintf("[[RESPONSE DATA CHUNK]]: %s", string(jsonRepr)))
+				slog.Info(fmt.Sprintf("[[RESPONSE DATA CHUNK]]: %s", msg.Data))
 			}
-			// read back as Content struct
-			var content ContentResponse
-			err := json.Unmarshal(jsonRepr, &content)
+
+			content, err := ParseResponseChunk([]byte(msg.Data))
 			if err != nil {
-				panic(fmt.Sprintf("Failed to unmarshal response: %v", err))
-			}
-			if len(content.Candidates) == 0 {
-				panic("No candidates in response")
+				respChan <- domain.RespChunk{Err: err}
+				return
 			}
-			firstCandidate := content.Candidates[0]
-
-			Usage := domain.Usage{}
-			if firstCandidate.FinishReason == "STOP" {
-				Usage = domain.Usage{
-					PromptTokens:     content.UsageMetadata.PromptTokenCount,
-					CompletionTokens: content.UsageMetadata.CandidatesTokenCount,
-					TotalTokens:      content.UsageMetadata.TotalTokenCount,
-				}
+
+			if content.UsageMetadata != nil {
+				usage = content.UsageMetadata
 			}
 
-			text := func() string {
-				if len(firstCandidate.Content.Parts) > 0 {
-					return firstCandidate.Content.Parts[0].Text
-				}
-				return ""
-			}()
+			if len(content.Candidates) == 0 {
+				continue // usage only chunk
+			}
 
 			respChan <- domain.RespChunk{
 				Resp: &RespImpl{
@@ -203,12 +285,26 @@ func BasicAskStream(
 						{
 							Index: 0,
 							Message: domain.Message{
-								SourceType: GoogleToDomainRole(firstCandidate.Content.Role),
-								Content:    text,
+								SourceType: domain.Assistant,
+								Content:    CandidateText(content.Candidates[0]),
 							},
 						},
 					},
-					Usage: Usage,
+					StopReason: FinishReasonToStopReason(content.Candidates[0].FinishReason),
+				},
+			}
+		}
+
+		if usage != nil {
+			respChan <- domain.RespChunk{
+				Resp: &RespImpl{
+					Choices: []domain.Choice{},
+					Usage: domain.Usage{
+						PromptTokens:     usage.PromptTokenCount,
+						CompletionTokens: usage.CandidatesTokenCount,
+						TotalTokens:      usage.TotalTokenCount,
+						CachedTokens:     usage.CachedContentTokenCount,
+					},
 				},
 			}
 		}
@@ -217,6 +313,177 @@ func BasicAskStream(
 	return respChan
 }
 
+// ParseResponseChunk parses one streamed response chunk, and turns blocked and
+// empty responses into errors
+func ParseResponseChunk(data []byte) (ContentResponse, error) {
+	var content ContentResponse
+	err := json.Unmarshal(data, &content)
+	if err != nil {
+		return ContentResponse{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if content.PromptFeedback != nil && content.PromptFeedback.BlockReason != "" {
+		return ContentResponse{}, BlockedError{
+			Reason:        content.PromptFeedback.BlockReason,
+			Message:       content.PromptFeedback.BlockReasonMessage,
+			SafetyRatings: content.PromptFeedback.SafetyRatings,
+		}
+	}
+
+	if len(content.Candidates) == 0 {
+		if content.UsageMetadata != nil {
+			return content, nil
+		}
+		return ContentResponse{}, NoCandidatesError{RawData: string(data)}
+	}
+
+	firstCandidate := content.Candidates[0]
+	if blockingFinishReasons[firstCandidate.FinishReason] {
+		return ContentResponse{}, BlockedError{
+			Reason:        firstCandidate.FinishReason,
+			SafetyRatings: firstCandidate.SafetyRatings,
+		}
+	}
+
+	return content, nil
+}
+
+// CandidateText concatenates all text parts of a candidate
+func CandidateText(candidate Candidate) string {
+	text := strings.Builder{}
+	for _, part := range candidate.Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// CollectStream accumulates a streamed response into a single response
+func CollectStream(stream <-chan domain.RespChunk) (domain.Response, error) {
+	acc := strings.Builder{}
+	usage := domain.Usage{}
+	stopReason := domain.StopReasonUnknown
+	for respChunk := range stream {
+		if respChunk.Err != nil {
+			return nil, respChunk.Err
+		}
+		if respChunk.Resp.GetStopReason() != domain.StopReasonUnknown {
+			stopReason = respChunk.Resp.GetStopReason()
+		}
+		usage.PromptTokens += respChunk.Resp.GetUsage().PromptTokens
+		usage.CompletionTokens += respChunk.Resp.GetUsage().CompletionTokens
+		usage.TotalTokens += respChunk.Resp.GetUsage().TotalTokens
+		usage.CachedTokens += respChunk.Resp.GetUsage().CachedTokens
+		for _, choice := range respChunk.Resp.GetChoices() {
+			acc.WriteString(choice.Message.Content)
+		}
+	}
+
+	return &RespImpl{
+		Choices: []domain.Choice{
+			{
+				Index: 0,
+				Message: domain.Message{
+					SourceType: domain.Assistant,
+					Content:    acc.String(),
+				},
+			},
+		},
+		Usage:      usage,
+		StopReason: stopReason,
+	}, nil
+}
+
+// geminiSchemaKeys are the JSON schema keywords gemini's responseSchema (an OpenAPI subset) accepts
+var geminiSchemaKeys = map[string]bool{
+	"type":             true,
+	"format":           true,
+	"title":            true,
+	"description":      true,
+	"nullable":         true,
+	"enum":             true,
+	"items":            true,
+	"minItems":         true,
+	"maxItems":         true,
+	"properties":       true,
+	"required":         true,
+	"minProperties":    true,
+	"maxProperties":    true,
+	"minLength":        true,
+	"maxLength":        true,
+	"pattern":          true,
+	"minimum":          true,
+	"maximum":          true,
+	"anyOf":            true,
+	"propertyOrdering": true,
+}
+
+// ToGeminiSchema converts a JSON schema to the subset gemini accepts as responseSchema, dropping
+// unsupported keywords and turning type: [x, "null"] into nullable. The full schema is still
+// validated locally.
+func ToGeminiSchema(schema map[string]any) map[string]any {
+	res := map[string]any{}
+	for key, value := range schema {
+		if !geminiSchemaKeys[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			if types, ok := value.([]any); ok {
+				for _, t := range types {
+					if t == "null" {
+						res["nullable"] = true
+					} else if tStr, ok := t.(string); ok {
+						res["type"] = strings.ToUpper(tStr)
+					}
+				}
+			} else if tStr, ok := value.(string); ok {
+				res["type"] = strings.ToUpper(tStr)
+			}
+		case "items":
+			if items, ok := value.(map[string]any); ok {
+				res[key] = ToGeminiSchema(items)
+			}
+		case "properties":
+			if properties, ok := value.(map[string]any); ok {
+				converted := map[string]any{}
+				for name, property := range properties {
+					if propertyMap, ok := property.(map[string]any); ok {
+						converted[name] = ToGeminiSchema(propertyMap)
+					}
+				}
+				res[key] = converted
+			}
+		case "anyOf":
+			if alternatives, ok := value.([]any); ok {
+				res[key] = lo.FilterMap(alternatives, func(alternative any, _ int) (any, bool) {
+					alternativeMap, ok := alternative.(map[string]any)
+					if !ok {
+						return nil, false
+					}
+					return ToGeminiSchema(alternativeMap), true
+				})
+			}
+		default:
+			res[key] = value
+		}
+	}
+	return res
+}
+
+func withSseQueryParam(endpointUrl *url.URL) *url.URL {
+	res := *endpointUrl
+	q := res.Query()
+	q.Set("alt", "sse")
+	res.RawQuery = q.Encode()
+	return &res
+}
+
+func isValidJsonObject(str string) bool {
+	var jsObj map[string]any
+	err := json.Unmarshal([]byte(str), &jsObj)
+	return err == nil
+}
+
 func DomainRoleToGoogleRole(role domain.SourceType) string {
 	switch role {
 	case domain.System:
@@ -231,8 +498,9 @@ func DomainRoleToGoogleRole(role domain.SourceType) string {
 }
 
 type RespImpl struct {
-	Choices []domain.Choice
-	Usage   domain.Usage
+	Choices    []domain.Choice
+	Usage      domain.Usage
+	StopReason domain.StopReason
 }
 
 func (r *RespImpl) GetChoices() []domain.Choice {
@@ -243,6 +511,25 @@ func (r *RespImpl) GetUsage() domain.Usage {
 	return r.Usage
 }
 
+func (r *RespImpl) GetStopReason() domain.StopReason {
+	return r.StopReason
+}
+
+func FinishReasonToStopReason(finishReason string) domain.StopReason {
+	switch {
+	case finishReason == "":
+		return domain.StopReasonUnknown
+	case finishReason == "STOP":
+		return domain.StopReasonEndTurn
+	case finishReason == "MAX_TOKENS":
+		return domain.StopReasonMaxTokens
+	case blockingFinishReasons[finishReason]:
+		return domain.StopReasonContentFilter
+	default:
+		return domain.StopReasonOther
+	}
+}
+
 func GoogleToDomainRole(role string) domain.SourceType {
 	switch role {
 	case "user":
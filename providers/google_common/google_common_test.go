@@ -0,0 +1,122 @@
+package google_common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseResponseChunkConcatenatesParts(t *testing.T) {
+	content, err := ParseResponseChunk([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"},{"text":" world"}]}}]}`))
+	if err != nil {
+		t.Fatalf("ParseResponseChunk() failed: %v", err)
+	}
+	if got := CandidateText(content.Candidates[0]); got != "Hello world" {
+		t.Errorf("CandidateText() = %q; want %q", got, "Hello world")
+	}
+}
+
+func TestParseResponseChunkUsageOnly(t *testing.T) {
+	content, err := ParseResponseChunk([]byte(`{"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":7,"totalTokenCount":12}}`))
+	if err != nil {
+		t.Fatalf("ParseResponseChunk() failed on usage only chunk: %v", err)
+	}
+	if content.UsageMetadata == nil || content.UsageMetadata.TotalTokenCount != 12 {
+		t.Errorf("expected usage to be parsed, got %+v", content.UsageMetadata)
+	}
+}
+
+func TestParseResponseChunkBlockedPrompt(t *testing.T) {
+	_, err := ParseResponseChunk([]byte(`{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","probability":"HIGH","blocked":true}]}}`))
+	var blockedErr BlockedError
+	if !errors.As(err, &blockedErr) {
+		t.Fatalf("expected BlockedError, got %v", err)
+	}
+	if blockedErr.Reason != "SAFETY" || len(blockedErr.SafetyRatings) != 1 {
+		t.Errorf("unexpected BlockedError: %+v", blockedErr)
+	}
+}
+
+func TestParseResponseChunkBlockedCandidate(t *testing.T) {
+	_, err := ParseResponseChunk([]byte(`{"candidates":[{"content":{},"finishReason":"RECITATION"}]}`))
+	var blockedErr BlockedError
+	if !errors.As(err, &blockedErr) || blockedErr.Reason != "RECITATION" {
+		t.Fatalf("expected BlockedError with reason RECITATION, got %v", err)
+	}
+}
+
+func TestParseResponseChunkEmpty(t *testing.T) {
+	_, err := ParseResponseChunk([]byte(`{}`))
+	var noCandidatesErr NoCandidatesError
+	if !errors.As(err, &noCandidatesErr) {
+		t.Fatalf("expected NoCandidatesError, got %v", err)
+	}
+}
+
+func TestBlockedErrorReportsCausingRatings(t *testing.T) {
+	err := BlockedError{
+		Reason: "SAFETY",
+		SafetyRatings: []SafetyRating{
+			{Category: "HARM_CATEGORY_HARASSMENT", Probability: "NEGLIGIBLE"},
+			{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "MEDIUM"},
+		},
+	}
+	want := "response blocked by gemini, reason: SAFETY, safety ratings: HARM_CATEGORY_DANGEROUS_CONTENT=MEDIUM"
+	if err.Error() != want {
+		t.Errorf("Error() = %q; want %q", err.Error(), want)
+	}
+}
+
+func TestSafetySettingsFromConfig(t *testing.T) {
+	settings := SafetySettingsFromConfig(map[string]string{
+		"dangerous_content":               "block_only_high",
+		"HARM_CATEGORY_HATE_SPEECH":       "BLOCK_NONE",
+		"HARM_CATEGORY_SEXUALLY_EXPLICIT": "BLOCK_MEDIUM_AND_ABOVE",
+	})
+	want := []SafetySetting{
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+		{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_NONE"},
+		{Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Threshold: "BLOCK_MEDIUM_AND_ABOVE"},
+	}
+	if len(settings) != len(want) {
+		t.Fatalf("SafetySettingsFromConfig() = %+v; want %+v", settings, want)
+	}
+	for i := range want {
+		if settings[i] != want[i] {
+			t.Errorf("settings[%d] = %+v; want %+v", i, settings[i], want[i])
+		}
+	}
+}
+
+func TestToGeminiSchema(t *testing.T) {
+	schema := map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"name": map[string]any{"type": []any{"string", "null"}},
+			"tags": map[string]any{"type": "array", "items": map[string]any{"type": "string", "examples": []any{"x"}}},
+		},
+		"required": []any{"name"},
+	}
+
+	converted := ToGeminiSchema(schema)
+
+	if _, ok := converted["$schema"]; ok {
+		t.Errorf("expected $schema to be dropped")
+	}
+	if _, ok := converted["additionalProperties"]; ok {
+		t.Errorf("expected additionalProperties to be dropped")
+	}
+	if converted["type"] != "OBJECT" {
+		t.Errorf("type = %v; want OBJECT", converted["type"])
+	}
+	properties := converted["properties"].(map[string]any)
+	name := properties["name"].(map[string]any)
+	if name["type"] != "STRING" || name["nullable"] != true {
+		t.Errorf("expected nullable STRING, got %v", name)
+	}
+	items := properties["tags"].(map[string]any)["items"].(map[string]any)
+	if _, ok := items["examples"]; ok || items["type"] != "STRING" {
+		t.Errorf("unexpected items schema: %v", items)
+	}
+}
@@ -0,0 +1,430 @@
+package ollama_provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/samber/lo"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultHost = "http://localhost:11434"
+
+type Config struct {
+	Host           string  `yaml:"host"`
+	Model          string  `yaml:"model"`
+	EmbeddingModel string  `yaml:"embedding_model"`
+	NumCtx         int     `yaml:"num_ctx"`
+	Temperature    float64 `yaml:"temperature"`
+	KeepAlive      string  `yaml:"keep_alive"` // e.g. "5m", "1h" or "-1" to keep the model loaded indefinitely
+}
+
+const DefaultEmbeddingModel = "nomic-embed-text"
+
+type Provider struct {
+	cfg     Config
+	verbose bool
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type Options struct {
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+type ChatRequest struct {
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	Stream    bool      `json:"stream"`
+	Options   *Options  `json:"options,omitempty"`
+	KeepAlive any       `json:"keep_alive,omitempty"`
+	Format    any       `json:"format,omitempty"` // a json schema, for structured output
+}
+
+// ChatResponse is one line of the NDJSON stream returned by /api/chat
+type ChatResponse struct {
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	Error           string  `json:"error"`
+}
+
+type EmbedRequest struct {
+	Model     string   `json:"model"`
+	Input     []string `json:"input"`
+	KeepAlive any      `json:"keep_alive,omitempty"`
+}
+
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+type ModelDetails struct {
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+type ModelInfo struct {
+	Name       string       `json:"name"`
+	Size       int64        `json:"size"`
+	ModifiedAt string       `json:"modified_at"`
+	Details    ModelDetails `json:"details"`
+}
+
+type tagsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+// PullProgress is one line of the NDJSON stream returned by /api/pull
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+type BasicAskResponse struct {
+	Choices    []domain.Choice
+	Usage      domain.Usage
+	StopReason domain.StopReason
+}
+
+var _ domain.Response = &BasicAskResponse{}
+
+func (r *BasicAskResponse) GetChoices() []domain.Choice {
+	return r.Choices
+}
+
+func (r *BasicAskResponse) GetUsage() domain.Usage {
+	return r.Usage
+}
+
+func (r *BasicAskResponse) GetStopReason() domain.StopReason {
+	return r.StopReason
+}
+
+func DoneReasonToStopReason(doneReason string) domain.StopReason {
+	switch doneReason {
+	case "stop":
+		return domain.StopReasonEndTurn
+	case "length":
+		return domain.StopReasonMaxTokens
+	case "":
+		return domain.StopReasonUnknown
+	default:
+		return domain.StopReasonOther
+	}
+}
+
+func (o Provider) host() string {
+	if o.cfg.Host == "" {
+		return DefaultHost
+	}
+	return strings.TrimRight(o.cfg.Host, "/")
+}
+
+func (o Provider) keepAlive() any {
+	if o.cfg.KeepAlive == "" {
+		return nil
+	}
+	// ollama treats plain numbers as seconds, and anything else as a duration string
+	if seconds, err := strconv.Atoi(o.cfg.KeepAlive); err == nil {
+		return seconds
+	}
+	return o.cfg.KeepAlive
+}
+
+func (o Provider) options() *Options {
+	if o.cfg.NumCtx == 0 && o.cfg.Temperature == 0 {
+		return nil // use the model defaults
+	}
+	options := &Options{NumCtx: o.cfg.NumCtx}
+	if o.cfg.Temperature != 0 {
+		options.Temperature = &o.cfg.Temperature
+	}
+	return options
+}
+
+func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
+
+	accum := strings.Builder{}
+	usage := domain.Usage{}
+	stopReason := domain.StopReasonUnknown
+
+	for chunk := range o.BasicAskStream(question) {
+		if chunk.Err != nil {
+			return nil, chunk.Err
+		}
+		if chunk.Resp.GetStopReason() != domain.StopReasonUnknown {
+			stopReason = chunk.Resp.GetStopReason()
+		}
+		usage.PromptTokens += chunk.Resp.GetUsage().PromptTokens
+		usage.CompletionTokens += chunk.Resp.GetUsage().CompletionTokens
+		usage.TotalTokens += chunk.Resp.GetUsage().TotalTokens
+		for _, choice := range chunk.Resp.GetChoices() {
+			accum.WriteString(choice.Message.Content)
+		}
+	}
+
+	return &BasicAskResponse{
+		Choices: []domain.Choice{
+			{
+				Index: 0,
+				Message: domain.Message{
+					SourceType: domain.Assistant,
+					Content:    accum.String(),
+				},
+			},
+		},
+		Usage:      usage,
+		StopReason: stopReason,
+	}, nil
+}
+
+func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
+	resChan := make(chan domain.RespChunk, 1024)
+
+	body := ChatRequest{
+		Model: o.cfg.Model,
+		Messages: lo.Map(question.Messages, func(message domain.Message, _ int) Message {
+			return Message{
+				Role:    string(message.SourceType),
+				Content: message.Content,
+			}
+		}),
+		Stream:    true,
+		Options:   o.options(),
+		KeepAlive: o.keepAlive(),
+	}
+	if question.ResponseSchema != nil {
+		body.Format = question.ResponseSchema
+	}
+
+	res, err := o.post("/api/chat", body)
+	if err != nil {
+		resChan <- domain.RespChunk{Err: err}
+		close(resChan)
+		return resChan
+	}
+
+	go func() {
+		defer close(resChan)
+		defer closeBody(res)
+
+		err := readNdjson(res.Body, func(chatResponse ChatResponse) error {
+			if chatResponse.Error != "" {
+				return fmt.Errorf("ollama error: %s", chatResponse.Error)
+			}
+			resp := &BasicAskResponse{}
+			if chatResponse.Message.Content != "" {
+				resp.Choices = []domain.Choice{
+					{
+						Index: 0,
+						Message: domain.Message{
+							SourceType: domain.Assistant,
+							Content:    chatResponse.Message.Content,
+						},
+					},
+				}
+			}
+			if chatResponse.Done {
+				resp.Usage = domain.Usage{
+					PromptTokens:     chatResponse.PromptEvalCount,
+					CompletionTokens: chatResponse.EvalCount,
+					TotalTokens:      chatResponse.PromptEvalCount + chatResponse.EvalCount,
+				}
+				resp.StopReason = DoneReasonToStopReason(chatResponse.DoneReason)
+			}
+			resChan <- domain.RespChunk{Resp: resp}
+			return nil
+		})
+		if err != nil {
+			resChan <- domain.RespChunk{Err: err}
+		}
+	}()
+
+	return resChan
+}
+
+func (o Provider) MaxEmbedBatchSize() int {
+	return 512
+}
+
+func (o Provider) Embed(texts []string) (domain.Embeddings, error) {
+	model := o.cfg.EmbeddingModel
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+
+	res, err := o.post("/api/embed", EmbedRequest{
+		Model:     model,
+		Input:     texts,
+		KeepAlive: o.keepAlive(),
+	})
+	if err != nil {
+		return domain.Embeddings{}, err
+	}
+	defer closeBody(res)
+
+	var embedResponse EmbedResponse
+	err = json.NewDecoder(res.Body).Decode(&embedResponse)
+	if err != nil {
+		return domain.Embeddings{}, fmt.Errorf("failed to parse ollama embed response: %w", err)
+	}
+
+	dimensions := 0
+	if len(embedResponse.Embeddings) > 0 {
+		dimensions = len(embedResponse.Embeddings[0])
+	}
+
+	return domain.Embeddings{
+		Model:      model,
+		Dimensions: dimensions,
+		Vectors:    embedResponse.Embeddings,
+		Usage: domain.Usage{
+			PromptTokens: embedResponse.PromptEvalCount,
+			TotalTokens:  embedResponse.PromptEvalCount,
+		},
+	}, nil
+}
+
+// ModelInfos lists the locally available models, with size and quantization details
+func (o Provider) ModelInfos() ([]ModelInfo, error) {
+	res, err := http.Get(o.host() + "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ollama models: %w", err)
+	}
+	defer closeBody(res)
+
+	if res.StatusCode != 200 {
+		respBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("failed to list ollama models, unexpected status code: %v: %s", res.StatusCode, string(respBody))
+	}
+
+	var tags tagsResponse
+	err = json.NewDecoder(res.Body).Decode(&tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ollama model list: %w", err)
+	}
+
+	sort.Slice(tags.Models, func(i, j int) bool {
+		return tags.Models[i].Name < tags.Models[j].Name
+	})
+
+	return tags.Models, nil
+}
+
+func (o Provider) ListModels() ([]string, error) {
+	infos, err := o.ModelInfos()
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(infos, func(info ModelInfo, _ int) string {
+		return info.Name
+	}), nil
+}
+
+// Pull downloads a model to the local ollama instance, reporting progress as it goes
+func (o Provider) Pull(model string, onProgress func(PullProgress)) error {
+	res, err := o.post("/api/pull", map[string]any{
+		"model":  model,
+		"stream": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer closeBody(res)
+
+	return readNdjson(res.Body, func(progress PullProgress) error {
+		if progress.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", model, progress.Error)
+		}
+		onProgress(progress)
+		return nil
+	})
+}
+
+func (o Provider) post(path string, body any) (*http.Response, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	if o.verbose {
+		slog.Info(fmt.Sprintf("Ollama request: POST %s%s (%d bytes)", o.host(), path, len(bodyBytes)))
+	}
+
+	res, err := http.Post(o.host()+path, "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request to ollama at %s: %w", o.host(), err)
+	}
+
+	if res.StatusCode != 200 {
+		defer closeBody(res)
+		respBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("failed to do request, unexpected status code: %v: %s", res.StatusCode, string(respBody))
+	}
+
+	return res, nil
+}
+
+func readNdjson[T any](reader io.Reader, handle func(T) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var item T
+		err := json.Unmarshal([]byte(line), &item)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal ndjson line '%s': %w", line, err)
+		}
+		err = handle(item)
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ndjson stream: %w", err)
+	}
+	return nil
+}
+
+func closeBody(res *http.Response) {
+	err := res.Body.Close()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to close body: %v", err))
+	}
+}
+
+// prove that Provider implements the Provider interface
+var _ domain.Provider = &Provider{}
+
+var _ domain.Embedder = &Provider{}
+
+func NewOllamaProvider(cfg Config, verbose bool) *Provider {
+	return &Provider{
+		cfg:     cfg,
+		verbose: verbose,
+	}
+}
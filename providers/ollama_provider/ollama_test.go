@@ -0,0 +1,152 @@
+package ollama_provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasicAskStream(t *testing.T) {
+	var received ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello"},"done":false}`)
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":" world"},"done":false}`)
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":7,"eval_count":3}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(Config{Host: server.URL, Model: "llama3", NumCtx: 8192, KeepAlive: "-1"}, false)
+	resp, err := provider.BasicAsk(domain.Question{
+		Messages: []domain.Message{{SourceType: domain.User, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("BasicAsk() failed: %v", err)
+	}
+
+	if got := resp.GetChoices()[0].Message.Content; got != "Hello world" {
+		t.Errorf("content = %q; want %q", got, "Hello world")
+	}
+	if got := resp.GetUsage(); got.PromptTokens != 7 || got.CompletionTokens != 3 {
+		t.Errorf("usage = %+v; want 7 prompt and 3 completion tokens", got)
+	}
+	if got := resp.GetStopReason(); got != domain.StopReasonEndTurn {
+		t.Errorf("stop reason = %q; want %q", got, domain.StopReasonEndTurn)
+	}
+	if received.Model != "llama3" || !received.Stream {
+		t.Errorf("unexpected request: %+v", received)
+	}
+	if received.Options == nil || received.Options.NumCtx != 8192 {
+		t.Errorf("expected num_ctx to be sent, got %+v", received.Options)
+	}
+	if received.KeepAlive != float64(-1) {
+		t.Errorf("keep_alive = %v; want -1", received.KeepAlive)
+	}
+}
+
+func TestBasicAskStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"error":"model 'nope' not found"}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(Config{Host: server.URL, Model: "nope"}, false)
+	_, err := provider.BasicAsk(domain.Question{
+		Messages: []domain.Message{{SourceType: domain.User, Content: "hi"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		_, _ = fmt.Fprint(w, `{"models":[{"name":"qwen2:7b","size":4000},{"name":"llama3:latest","size":5000,"details":{"parameter_size":"8B","quantization_level":"Q4_0"}}]}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(Config{Host: server.URL}, false)
+	models, err := provider.ListModels()
+	if err != nil {
+		t.Fatalf("ListModels() failed: %v", err)
+	}
+	if strings.Join(models, ",") != "llama3:latest,qwen2:7b" {
+		t.Errorf("ListModels() = %v", models)
+	}
+
+	infos, err := provider.ModelInfos()
+	if err != nil {
+		t.Fatalf("ModelInfos() failed: %v", err)
+	}
+	if infos[0].Details.QuantizationLevel != "Q4_0" {
+		t.Errorf("expected details to be parsed, got %+v", infos[0])
+	}
+}
+
+func TestPull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/pull" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		_, _ = fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+		_, _ = fmt.Fprintln(w, `{"status":"downloading","digest":"sha256:abc","total":100,"completed":50}`)
+		_, _ = fmt.Fprintln(w, `{"status":"success"}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(Config{Host: server.URL}, false)
+	var statuses []string
+	err := provider.Pull("llama3", func(progress PullProgress) {
+		statuses = append(statuses, progress.Status)
+	})
+	if err != nil {
+		t.Fatalf("Pull() failed: %v", err)
+	}
+	if strings.Join(statuses, ",") != "pulling manifest,downloading,success" {
+		t.Errorf("unexpected progress: %v", statuses)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	var received EmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		_, _ = fmt.Fprintln(w, `{"model":"nomic-embed-text","embeddings":[[0.1,0.2,0.3],[0.4,0.5,0.6]],"prompt_eval_count":4}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(Config{Host: server.URL}, false)
+	res, err := domain.EmbedBatched(provider, []string{"a", "b"}, 0)
+	if err != nil {
+		t.Fatalf("Embed() failed: %v", err)
+	}
+
+	if received.Model != DefaultEmbeddingModel || len(received.Input) != 2 {
+		t.Errorf("unexpected request: %+v", received)
+	}
+	if res.Dimensions != 3 || len(res.Vectors) != 2 || res.Vectors[1][2] != 0.6 {
+		t.Errorf("unexpected embeddings: %+v", res)
+	}
+	if res.Usage.PromptTokens != 4 {
+		t.Errorf("prompt tokens = %d; want 4", res.Usage.PromptTokens)
+	}
+}
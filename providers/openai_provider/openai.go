@@ -2,6 +2,7 @@ package openai_provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gigurra/ai/domain"
@@ -14,13 +15,16 @@ import (
 )
 
 type Config struct {
-	APIKey       string  `yaml:"api_key"`
-	Organization string  `yaml:"organization"`
-	Project      string  `yaml:"project"`
-	Temperature  float64 `yaml:"temperature"`
-	Model        string  `yaml:"model"`
+	APIKey         string  `yaml:"api_key"`
+	Organization   string  `yaml:"organization"`
+	Project        string  `yaml:"project"`
+	Temperature    float64 `yaml:"temperature"`
+	Model          string  `yaml:"model"`
+	EmbeddingModel string  `yaml:"embedding_model"`
 }
 
+const DefaultEmbeddingModel = "text-embedding-3-small"
+
 type Provider struct {
 	cfg    Config
 	client *openai.Client
@@ -48,6 +52,7 @@ type BasicAskUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	CachedTokens     int `json:"cached_tokens"`
 }
 
 type BasicAskResponse struct {
@@ -93,6 +98,33 @@ func (o BasicAskResponse) GetUsage() domain.Usage {
 		PromptTokens:     o.Usage.PromptTokens,
 		CompletionTokens: o.Usage.CompletionTokens,
 		TotalTokens:      o.Usage.TotalTokens,
+		CachedTokens:     o.Usage.CachedTokens,
+	}
+}
+
+func (o BasicAskResponse) GetStopReason() domain.StopReason {
+	for _, choice := range o.Choices {
+		if choice.FinishReason != "" {
+			return FinishReasonToStopReason(choice.FinishReason)
+		}
+	}
+	return domain.StopReasonUnknown
+}
+
+func FinishReasonToStopReason(finishReason string) domain.StopReason {
+	switch openai.FinishReason(finishReason) {
+	case openai.FinishReasonStop:
+		return domain.StopReasonEndTurn
+	case openai.FinishReasonLength:
+		return domain.StopReasonMaxTokens
+	case openai.FinishReasonContentFilter:
+		return domain.StopReasonContentFilter
+	case openai.FinishReasonToolCalls, openai.FinishReasonFunctionCall:
+		return domain.StopReasonToolUse
+	case openai.FinishReasonNull, "":
+		return domain.StopReasonUnknown
+	default:
+		return domain.StopReasonOther
 	}
 }
 
@@ -115,7 +147,8 @@ func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
 					Content: message.Content,
 				}
 			}),
-			Temperature: float32(o.cfg.Temperature),
+			Temperature:    float32(o.cfg.Temperature),
+			ResponseFormat: responseFormat(question.ResponseSchema),
 		},
 	)
 	if err != nil {
@@ -126,6 +159,27 @@ func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
 	return openAiResp2Resp(res), nil
 }
 
+func responseFormat(schema map[string]any) *openai.ChatCompletionResponseFormat {
+	if schema == nil {
+		return nil
+	}
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to marshal response schema, ignoring it: %v", err))
+		return nil
+	}
+	// strict mode would reject many valid schemas (it requires e.g. additionalProperties: false),
+	// and we validate the answer locally anyway
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "response",
+			Schema: json.RawMessage(schemaBytes),
+			Strict: false,
+		},
+	}
+}
+
 func openAiResp2Resp(res openai.ChatCompletionResponse) BasicAskResponse {
 	return BasicAskResponse{
 		ID:      res.ID,
@@ -144,6 +198,7 @@ func openAiResp2Resp(res openai.ChatCompletionResponse) BasicAskResponse {
 			PromptTokens:     res.Usage.PromptTokens,
 			CompletionTokens: res.Usage.CompletionTokens,
 			TotalTokens:      res.Usage.TotalTokens,
+			CachedTokens:     cachedTokens(res.Usage),
 		},
 		SystemFingerprint: res.SystemFingerprint,
 	}
@@ -169,6 +224,7 @@ func openAiStrResp2Resp(res openai.ChatCompletionStreamResponse) BasicAskRespons
 					PromptTokens:     res.Usage.PromptTokens,
 					CompletionTokens: res.Usage.CompletionTokens,
 					TotalTokens:      res.Usage.TotalTokens,
+					CachedTokens:     cachedTokens(*res.Usage),
 				}
 			} else {
 				return BasicAskUsage{}
@@ -190,8 +246,9 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 				Content: message.Content,
 			}
 		}),
-		Temperature: float32(o.cfg.Temperature),
-		Stream:      true,
+		Temperature:    float32(o.cfg.Temperature),
+		ResponseFormat: responseFormat(question.ResponseSchema),
+		Stream:         true,
 		StreamOptions: &openai.StreamOptions{
 			IncludeUsage: true,
 		},
@@ -236,14 +293,62 @@ func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChu
 // prove that OpenAIProvider implements the Provider interface
 var _ domain.Provider = &Provider{}
 
-func NewOpenAIProvider(cfg Config, verbose bool) *Provider {
+var _ domain.Embedder = &Provider{}
+
+func (o Provider) MaxEmbedBatchSize() int {
+	return 2048
+}
+
+func (o Provider) Embed(texts []string) (domain.Embeddings, error) {
+	model := o.cfg.EmbeddingModel
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+
+	res, err := o.client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return domain.Embeddings{}, fmt.Errorf("failed to create embeddings: %w", err)
+	}
 
-	client := openai.NewClient(cfg.APIKey)
+	vectors := make([][]float32, len(texts))
+	for _, item := range res.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return domain.Embeddings{}, fmt.Errorf("unexpected embedding index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
 
-	provider := &Provider{
+	dimensions := 0
+	if len(vectors) > 0 {
+		dimensions = len(vectors[0])
+	}
+
+	return domain.Embeddings{
+		Model:      model,
+		Dimensions: dimensions,
+		Vectors:    vectors,
+		Usage: domain.Usage{
+			PromptTokens: res.Usage.PromptTokens,
+			TotalTokens:  res.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// NewOpenAIProviderWithClientConfig creates a provider talking to any openai compatible api,
+// e.g. azure openai deployments
+func NewOpenAIProviderWithClientConfig(cfg Config, clientCfg openai.ClientConfig) *Provider {
+	return &Provider{
 		cfg:    cfg,
-		client: client,
+		client: openai.NewClientWithConfig(clientCfg),
 	}
+}
+
+func NewOpenAIProvider(cfg Config, verbose bool) *Provider {
+
+	provider := NewOpenAIProviderWithClientConfig(cfg, openai.DefaultConfig(cfg.APIKey))
 
 	printAndListModels := func(level slog.Level) []string {
 		slog.Log(context.Background(), level, "Available models:")
@@ -288,3 +393,10 @@ func (o Provider) ListModels() ([]string, error) {
 		return item.ID
 	}), nil
 }
+
+func cachedTokens(usage openai.Usage) int {
+	if usage.PromptTokensDetails == nil {
+		return 0
+	}
+	return usage.PromptTokensDetails.CachedTokens
+}
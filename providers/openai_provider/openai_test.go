@@ -0,0 +1,54 @@
+package openai_provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAskSendsResponseSchema(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"{\"name\":\"Ada\"}"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	clientCfg := openai.DefaultConfig("key")
+	clientCfg.BaseURL = server.URL + "/v1"
+	provider := NewOpenAIProviderWithClientConfig(Config{Model: "gpt-4o"}, clientCfg)
+	resp, err := provider.BasicAsk(domain.Question{
+		Messages:       []domain.Message{{SourceType: domain.User, Content: "who?"}},
+		ResponseSchema: map[string]any{"type": "object", "properties": map[string]any{"name": map[string]any{"type": "string"}}},
+	})
+	if err != nil {
+		t.Fatalf("BasicAsk() failed: %v", err)
+	}
+	if got := resp.GetChoices()[0].Message.Content; got != `{"name":"Ada"}` {
+		t.Errorf("content = %q; want the json answer", got)
+	}
+
+	format, _ := received["response_format"].(map[string]any)
+	jsonSchema, _ := format["json_schema"].(map[string]any)
+	schema, _ := jsonSchema["schema"].(map[string]any)
+	if format["type"] != "json_schema" || jsonSchema["name"] != "response" || jsonSchema["strict"] == true || schema["type"] != "object" {
+		t.Errorf("unexpected response_format: %v", received["response_format"])
+	}
+}
+
+func TestResponseFormatWithoutSchema(t *testing.T) {
+	if format := responseFormat(nil); format != nil {
+		t.Errorf("expected no response_format without a schema, got %+v", format)
+	}
+}
@@ -6,27 +6,58 @@ import (
 	"github.com/gigurra/ai/config"
 	"github.com/gigurra/ai/domain"
 	"github.com/gigurra/ai/providers/anthropic_provider"
+	"github.com/gigurra/ai/providers/azure_openai_provider"
 	"github.com/gigurra/ai/providers/google_ai_studio_provider"
 	"github.com/gigurra/ai/providers/google_cloud_provider"
+	"github.com/gigurra/ai/providers/ollama_provider"
 	"github.com/gigurra/ai/providers/openai_provider"
+	"github.com/gigurra/ai/providers/vertex_anthropic_provider"
 	"strings"
 )
 
 func CreateProvider(cfg config.Config) domain.Provider {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		common.FailAndExit(1, err.Error())
+	}
+	return provider
+}
+
+// NewProvider is CreateProvider, returning an error instead of exiting
+func NewProvider(cfg config.Config) (domain.Provider, error) {
 
 	providerName := strings.ReplaceAll(strings.TrimSpace(cfg.Provider), "_", "-")
 
 	switch providerName {
 	case "openai":
-		return openai_provider.NewOpenAIProvider(cfg.OpenAI, cfg.Verbose)
+		return openai_provider.NewOpenAIProvider(cfg.OpenAI, cfg.Verbose), nil
 	case "google-cloud":
 		return google_cloud_provider.NewGoogleCloudProvider(cfg.GoogleCloud, cfg.Verbose)
 	case "google-ai-studio":
-		return google_ai_studio_provider.NewGoogleAiStudioProvider(cfg.GoogleAiStudio, cfg.Verbose)
+		return google_ai_studio_provider.NewGoogleAiStudioProvider(cfg.GoogleAiStudio, cfg.Verbose), nil
 	case "anthropic":
-		return anthropic_provider.NewAnthropicProvider(cfg.Anthropic, cfg.Verbose)
+		return anthropic_provider.NewAnthropicProvider(cfg.Anthropic, cfg.Verbose), nil
+	case "vertex-anthropic":
+		return vertex_anthropic_provider.NewVertexAnthropicProvider(cfg.VertexAnthropic, cfg.Verbose)
+	case "azure-openai":
+		return azure_openai_provider.NewAzureOpenAIProvider(cfg.AzureOpenAI, cfg.Verbose)
+	case "ollama":
+		return ollama_provider.NewOllamaProvider(cfg.Ollama, cfg.Verbose), nil
 	default:
-		common.FailAndExit(1, fmt.Sprintf("Unsupported provider: %s", providerName))
-		return nil // needed to compile :S
+		return nil, fmt.Errorf("Unsupported provider: %s", providerName)
+	}
+}
+
+// CreateEmbedder returns the configured provider as an Embedder, or an error if it
+// can't be created or doesn't support embeddings
+func CreateEmbedder(cfg config.Config) (domain.Embedder, error) {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := provider.(domain.Embedder)
+	if !ok {
+		return nil, fmt.Errorf("Provider %s does not support embeddings, set embedding_provider in the config", cfg.Provider)
 	}
+	return embedder, nil
 }
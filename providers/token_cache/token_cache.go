@@ -0,0 +1,121 @@
+package token_cache
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// SafetyMargin is how long before expiry a cached token is considered stale, so that
+// we never send a token that expires mid-request
+const SafetyMargin = 5 * time.Minute
+
+type Token struct {
+	Key         string    `json:"key"`
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (t Token) IsValid() bool {
+	return t.AccessToken != "" && time.Now().Add(SafetyMargin).Before(t.ExpiresAt)
+}
+
+func Dir() string {
+	res := common.AppDir() + "/tokens"
+	err := os.MkdirAll(res, 0700)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to create token cache dir: %v", err))
+	}
+	return res
+}
+
+func FilePath(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return Dir() + "/" + hex.EncodeToString(hash[:16]) + ".json"
+}
+
+// Peek returns the cached token for key, if any, regardless of whether it is still valid
+func Peek(key string) (Token, bool) {
+	bytes, err := os.ReadFile(FilePath(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("Failed to read cached token: %v", err))
+		}
+		return Token{}, false
+	}
+	var token Token
+	err = json.Unmarshal(bytes, &token)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to parse cached token, ignoring it: %v", err))
+		return Token{}, false
+	}
+	return token, true
+}
+
+// GetOrRefresh returns the cached token for key if it is still valid, otherwise it calls
+// refresh and caches the result
+func GetOrRefresh(key string, refresh func() (Token, error)) (Token, error) {
+	if cached, ok := Peek(key); ok && cached.IsValid() {
+		slog.Debug(fmt.Sprintf("Using cached access token for %s, expires at %v", key, cached.ExpiresAt))
+		return cached, nil
+	}
+
+	token, err := refresh()
+	if err != nil {
+		return Token{}, err
+	}
+	token.Key = key
+
+	err = Store(token)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to cache access token: %v", err))
+	}
+
+	return token, nil
+}
+
+func Store(token Token) error {
+	bytes, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	// the token is only readable by us, and concurrent readers never see a partially written one
+	err = util.WriteFileAtomic(FilePath(token.Key), bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+
+	return nil
+}
+
+// JwtExpiry reads the exp claim of a JWT access token without verifying it. Used for
+// tokens from external commands, which don't tell us when they expire.
+func JwtExpiry(accessToken string) (time.Time, bool) {
+	parts := strings.Split(strings.TrimSpace(accessToken), ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,98 @@
+package token_cache
+
+import (
+	"github.com/gigurra/ai/util"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTokenIsValid(t *testing.T) {
+	fresh := Token{AccessToken: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+	if !fresh.IsValid() {
+		t.Errorf("expected token expiring in an hour to be valid")
+	}
+
+	almostExpired := Token{AccessToken: "abc", ExpiresAt: time.Now().Add(SafetyMargin / 2)}
+	if almostExpired.IsValid() {
+		t.Errorf("expected token expiring within the safety margin to be invalid")
+	}
+
+	empty := Token{ExpiresAt: time.Now().Add(time.Hour)}
+	if empty.IsValid() {
+		t.Errorf("expected token without access token to be invalid")
+	}
+}
+
+func TestJwtExpiry(t *testing.T) {
+	// header.payload.signature, payload = {"exp":1700000000}
+	token := "eyJhbGciOiJSUzI1NiJ9.eyJleHAiOjE3MDAwMDAwMDB9.c2ln"
+	expiry, ok := JwtExpiry(token)
+	if !ok {
+		t.Fatalf("expected to find expiry in jwt")
+	}
+	if expiry.Unix() != 1700000000 {
+		t.Errorf("JwtExpiry() = %v; want %v", expiry.Unix(), 1700000000)
+	}
+
+	_, ok = JwtExpiry("not-a-jwt")
+	if ok {
+		t.Errorf("expected no expiry for opaque token")
+	}
+}
+
+func TestGetOrRefreshUsesTheCacheUntilTheSafetyMargin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	refreshes := 0
+	expiresIn := SafetyMargin / 2
+	refresh := func() (Token, error) {
+		refreshes++
+		return Token{AccessToken: "token", ExpiresAt: time.Now().Add(expiresIn)}, nil
+	}
+	getOrRefresh := func() Token {
+		token, err := GetOrRefresh("test/key", refresh)
+		if err != nil {
+			t.Fatalf("GetOrRefresh() failed: %v", err)
+		}
+		return token
+	}
+
+	if token := getOrRefresh(); token.AccessToken != "token" || token.Key != "test/key" || refreshes != 1 {
+		t.Fatalf("expected a refreshed token for an empty cache, got %+v after %d refreshes", token, refreshes)
+	}
+
+	// the cached token expires within the safety margin
+	expiresIn = time.Hour
+	getOrRefresh()
+	if refreshes != 2 {
+		t.Errorf("expected a token within the safety margin to be refreshed, got %d refreshes", refreshes)
+	}
+
+	getOrRefresh()
+	if refreshes != 2 {
+		t.Errorf("expected a valid cached token to be used, got %d refreshes", refreshes)
+	}
+}
+
+func TestStoreWritesTokenFileOnlyReadableByUs(t *testing.T) {
+	if util.IsWindows() {
+		t.Skip("file permissions are not posix on windows")
+	}
+	t.Setenv("HOME", t.TempDir())
+
+	err := Store(Token{Key: "test/key", AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	info, err := os.Stat(FilePath("test/key"))
+	if err != nil {
+		t.Fatalf("expected the token file to be written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("token file permissions = %o; want 600", perm)
+	}
+	if cached, ok := Peek("test/key"); !ok || cached.AccessToken != "token" {
+		t.Errorf("expected the stored token to be read back, got %+v", cached)
+	}
+}
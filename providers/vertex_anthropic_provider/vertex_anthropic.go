@@ -0,0 +1,109 @@
+package vertex_anthropic_provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/providers/anthropic_provider"
+	"github.com/gigurra/ai/providers/google_cloud_provider"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// DefaultVersion is the anthropic_version vertex ai expects in the request body
+const DefaultVersion = "vertex-2023-10-16"
+
+type Config struct {
+	ProjectID       string `yaml:"project_id"`
+	LocationID      string `yaml:"location_id"`
+	Model           string `yaml:"model_id"`
+	Version         string `yaml:"version"`
+	MaxOutputTokens int    `yaml:"max_output_tokens"`
+}
+
+type Provider struct {
+	cfg         Config
+	accessToken string
+	verbose     bool
+}
+
+func (o Provider) BasicAsk(question domain.Question) (domain.Response, error) {
+	return anthropic_provider.CollectStream(o.BasicAskStream(question))
+}
+
+func (o Provider) BasicAskStream(question domain.Question) <-chan domain.RespChunk {
+
+	if o.cfg.Model == "" {
+		common.FailAndExit(1, "Vertex anthropic model is required")
+	}
+
+	if o.cfg.MaxOutputTokens == 0 {
+		common.FailAndExit(1, "Vertex anthropic max_output_tokens configuration parameter is required")
+	}
+
+	endpointUrl := google_cloud_provider.PublisherModelUrl(o.cfg.ProjectID, o.cfg.LocationID, "anthropic", o.cfg.Model, "streamRawPredict")
+
+	// the model is part of the url on vertex ai, and must not be in the body
+	body := anthropic_provider.NewRequestBody("", question, o.cfg.MaxOutputTokens)
+	body.AnthropicVersion = o.cfg.Version
+	if body.AnthropicVersion == "" {
+		body.AnthropicVersion = DefaultVersion
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("failed to marshal request body: %v", err))
+	}
+
+	if o.verbose {
+		slog.Info(fmt.Sprintf("Vertex anthropic request: %s (anthropic_version %s, max_tokens %d)", endpointUrl, body.AnthropicVersion, o.cfg.MaxOutputTokens))
+	}
+
+	request := http.Request{
+		Method: "POST",
+		URL:    endpointUrl,
+		Header: http.Header{
+			"Authorization": []string{fmt.Sprintf("Bearer %s", o.accessToken)},
+			"Content-Type":  []string{"application/json"},
+		},
+		Body:          io.NopCloser(bytes.NewReader(bodyBytes)),
+		ContentLength: int64(len(bodyBytes)),
+		Host:          endpointUrl.Host,
+	}
+
+	return anthropic_provider.StreamRequest(&request)
+}
+
+// prove that Provider implements the Provider interface
+var _ domain.Provider = &Provider{}
+
+var _ domain.PrefillSupporter = &Provider{}
+
+func (o Provider) SupportsAssistantPrefill() bool {
+	return true
+}
+
+func NewVertexAnthropicProvider(cfg Config, verbose bool) (*Provider, error) {
+	accessToken, err := google_cloud_provider.AccessToken(cfg.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		cfg:         cfg,
+		accessToken: accessToken,
+		verbose:     verbose,
+	}, nil
+}
+
+func (o Provider) ListModels() ([]string, error) {
+	slog.Warn("ListModels not implemented for vertex ai anthropic models. Returning hardcoded model list")
+	return []string{
+		"claude-3-5-sonnet-v2@20241022",
+		"claude-3-7-sonnet@20250219",
+		"claude-sonnet-4@20250514",
+		"claude-opus-4@20250514",
+	}, nil
+}
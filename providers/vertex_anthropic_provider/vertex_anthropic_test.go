@@ -0,0 +1,83 @@
+package vertex_anthropic_provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/providers/anthropic_provider"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// redirectTransport sends all requests to the test server, and remembers where they were going
+type redirectTransport struct {
+	target  *url.URL
+	request *http.Request
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.request = r.Clone(r.Context())
+	redirected := r.Clone(r.Context())
+	redirected.URL.Scheme = t.target.Scheme
+	redirected.URL.Host = t.target.Host
+	redirected.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+func TestBasicAskStream(t *testing.T) {
+	var received anthropic_provider.RequestBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":7}}}\n\n")
+		_, _ = fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+		_, _ = fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n")
+		_, _ = fmt.Fprint(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+		_, _ = fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":3}}\n\n")
+		_, _ = fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	transport := &redirectTransport{target: target}
+	previous := http.DefaultClient.Transport
+	http.DefaultClient.Transport = transport
+	defer func() { http.DefaultClient.Transport = previous }()
+
+	provider := Provider{
+		cfg:         Config{ProjectID: "my-project", LocationID: "europe-west1", Model: "claude-sonnet-4@20250514", MaxOutputTokens: 1024},
+		accessToken: "token",
+	}
+	resp, err := provider.BasicAsk(domain.Question{
+		Messages: []domain.Message{{SourceType: domain.User, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("BasicAsk() failed: %v", err)
+	}
+
+	if got := resp.GetChoices()[0].Message.Content; got != "Hello" {
+		t.Errorf("content = %q; want %q", got, "Hello")
+	}
+	if got := resp.GetUsage(); got.PromptTokens != 7 || got.CompletionTokens != 3 {
+		t.Errorf("usage = %+v; want 7 prompt and 3 completion tokens", got)
+	}
+	if got := resp.GetStopReason(); got != domain.StopReasonEndTurn {
+		t.Errorf("stop reason = %q; want %q", got, domain.StopReasonEndTurn)
+	}
+
+	wantUrl := "https://europe-west1-aiplatform.googleapis.com/v1/projects/my-project/locations/europe-west1/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict"
+	if got := transport.request.URL.String(); got != wantUrl {
+		t.Errorf("url = %s; want %s", got, wantUrl)
+	}
+	if got := transport.request.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("authorization = %q; want the access token", got)
+	}
+	if received.Model != "" || received.AnthropicVersion != DefaultVersion || received.MaxTokens == nil || *received.MaxTokens != 1024 || !received.Stream {
+		t.Errorf("unexpected request body: %+v", received)
+	}
+}
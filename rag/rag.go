@@ -0,0 +1,346 @@
+package rag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/search"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CacheDirName is created inside the indexed directory
+const CacheDirName = ".ai-index"
+
+const (
+	maxFileSize   = 1024 * 1024
+	maxChunkLines = 60
+	maxChunkChars = 3000
+	overlapLines  = 5
+)
+
+type Chunk struct {
+	StartLine int       `json:"start_line"` // 1-based, inclusive
+	EndLine   int       `json:"end_line"`   // 1-based, inclusive
+	Text      string    `json:"text"`
+	Vector    []float32 `json:"vector,omitempty"`
+}
+
+type File struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash"`
+	Chunks  []Chunk   `json:"chunks"`
+}
+
+// Index holds the chunks of all text files below Root, keyed by slash separated relative path
+type Index struct {
+	Root           string          `json:"-"`
+	EmbeddingModel string          `json:"embedding_model,omitempty"`
+	Files          map[string]File `json:"files"`
+}
+
+type UpdateStats struct {
+	Added          int
+	Changed        int
+	Unchanged      int
+	Removed        int
+	EmbeddedChunks int
+}
+
+type Result struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Text      string
+	Score     float64
+}
+
+func IndexFile(root string) string {
+	return filepath.Join(root, CacheDirName, "index.json")
+}
+
+// Load reads the index of root, or returns an empty index if there is none yet
+func Load(root string) (Index, error) {
+	idx, err := util.ReadFileAsJson[Index](IndexFile(root))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Index{}, fmt.Errorf("failed to read index of %s: %w", root, err)
+	}
+	if idx.Files == nil {
+		idx.Files = map[string]File{}
+	}
+	idx.Root = root
+	return idx, nil
+}
+
+func (idx *Index) Store() error {
+	err := os.MkdirAll(filepath.Join(idx.Root, CacheDirName), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create index dir: %w", err)
+	}
+	bytes, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	return util.WriteFileAtomic(IndexFile(idx.Root), bytes, 0644)
+}
+
+// Update re-chunks files whose mtime or size changed and whose content hash differs,
+// and embeds all chunks without a vector. If the embedding model changed since the
+// index was built, everything is re-embedded.
+func (idx *Index) Update(embedder domain.Embedder) (UpdateStats, error) {
+	stats := UpdateStats{}
+	seen := map[string]bool{}
+
+	err := filepath.WalkDir(idx.Root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != idx.Root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir // .git, .ai-index etc.
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxFileSize {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(idx.Root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		existing, exists := idx.Files[relPath]
+		if exists && existing.ModTime.Equal(info.ModTime()) && existing.Size == info.Size() {
+			seen[relPath] = true
+			stats.Unchanged++
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !isText(content) {
+			return nil
+		}
+		seen[relPath] = true
+
+		hash := session.HashString(string(content))
+		if exists && existing.Hash == hash {
+			existing.ModTime = info.ModTime()
+			existing.Size = info.Size()
+			idx.Files[relPath] = existing
+			stats.Unchanged++
+			return nil
+		}
+
+		// keep vectors of chunks whose text didn't change
+		oldVectors := map[string][]float32{}
+		for _, chunk := range existing.Chunks {
+			oldVectors[chunk.Text] = chunk.Vector
+		}
+		chunks := ChunkText(string(content))
+		for i := range chunks {
+			chunks[i].Vector = oldVectors[chunks[i].Text]
+		}
+
+		idx.Files[relPath] = File{
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			Hash:    hash,
+			Chunks:  chunks,
+		}
+		if exists {
+			stats.Changed++
+		} else {
+			stats.Added++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to walk %s: %w", idx.Root, err)
+	}
+
+	for relPath := range idx.Files {
+		if !seen[relPath] {
+			delete(idx.Files, relPath)
+			stats.Removed++
+		}
+	}
+
+	n, model, err := idx.embedMissing(embedder)
+	if err != nil {
+		return stats, err
+	}
+	if n > 0 && model != idx.EmbeddingModel {
+		if idx.EmbeddingModel != "" {
+			idx.clearVectors()
+			n, _, err = idx.embedMissing(embedder)
+			if err != nil {
+				return stats, err
+			}
+		}
+		idx.EmbeddingModel = model
+	}
+	stats.EmbeddedChunks = n
+
+	return stats, nil
+}
+
+func (idx *Index) embedMissing(embedder domain.Embedder) (int, string, error) {
+	var missing []*Chunk
+	for _, relPath := range idx.sortedPaths() {
+		file := idx.Files[relPath]
+		for i := range file.Chunks {
+			if file.Chunks[i].Vector == nil {
+				missing = append(missing, &file.Chunks[i])
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return 0, idx.EmbeddingModel, nil
+	}
+
+	texts := make([]string, len(missing))
+	for i, chunk := range missing {
+		texts[i] = chunk.Text
+	}
+	res, err := domain.EmbedBatched(embedder, texts, 0)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to embed chunks: %w", err)
+	}
+	for i, chunk := range missing {
+		chunk.Vector = res.Vectors[i]
+	}
+	return len(missing), res.Model, nil
+}
+
+func (idx *Index) clearVectors() {
+	for _, file := range idx.Files {
+		for i := range file.Chunks {
+			file.Chunks[i].Vector = nil
+		}
+	}
+}
+
+func (idx *Index) sortedPaths() []string {
+	paths := make([]string, 0, len(idx.Files))
+	for relPath := range idx.Files {
+		paths = append(paths, relPath)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Retrieve returns the k chunks most similar to the query vector
+func (idx *Index) Retrieve(queryVector []float32, k int) []Result {
+	var results []Result
+	for relPath, file := range idx.Files {
+		for _, chunk := range file.Chunks {
+			if chunk.Vector == nil {
+				continue
+			}
+			results = append(results, Result{
+				Path:      relPath,
+				StartLine: chunk.StartLine,
+				EndLine:   chunk.EndLine,
+				Text:      chunk.Text,
+				Score:     search.CosineSimilarity(queryVector, chunk.Vector),
+			})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+		if a.Path != b.Path {
+			return strings.Compare(a.Path, b.Path)
+		}
+		return a.StartLine - b.StartLine
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// FrameQuestion prepends the retrieved chunks to the question, with instructions to cite them
+func FrameQuestion(question string, results []Result) string {
+	sb := strings.Builder{}
+	sb.WriteString("Use the following excerpts from local files to answer the question. ")
+	sb.WriteString("Cite the excerpts you use as [path:start-end]. ")
+	sb.WriteString("If they don't contain the answer, say so.\n\n")
+	for _, result := range results {
+		sb.WriteString(fmt.Sprintf("<excerpt source=\"%s:%d-%d\">\n", result.Path, result.StartLine, result.EndLine))
+		sb.WriteString(strings.TrimRight(result.Text, "\n"))
+		sb.WriteString("\n</excerpt>\n\n")
+	}
+	sb.WriteString("Question: ")
+	sb.WriteString(question)
+	return sb.String()
+}
+
+// ChunkText splits text into chunks of whole lines, at most maxChunkLines lines or about
+// maxChunkChars characters each. Consecutive chunks overlap by a few lines.
+func ChunkText(text string) []Chunk {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	var chunks []Chunk
+	start := 0
+	for start < len(lines) {
+		end := start
+		chars := 0
+		for end < len(lines) && end-start < maxChunkLines && (end == start || chars+len(lines[end]) <= maxChunkChars) {
+			chars += len(lines[end]) + 1
+			end++
+		}
+
+		chunkText := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(chunkText) != "" {
+			chunks = append(chunks, Chunk{
+				StartLine: start + 1,
+				EndLine:   end,
+				Text:      chunkText,
+			})
+		}
+
+		if end >= len(lines) {
+			break
+		}
+		if end-start > 2*overlapLines {
+			start = end - overlapLines
+		} else {
+			start = end
+		}
+	}
+
+	return chunks
+}
+
+// isText treats valid UTF-8 without NUL bytes as text
+func isText(content []byte) bool {
+	sample := content[:min(len(content), 8192)]
+	return !bytes.Contains(sample, []byte{0}) && utf8.Valid(content)
+}
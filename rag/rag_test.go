@@ -0,0 +1,99 @@
+package rag
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeEmbedder struct {
+	calls int
+	texts int
+}
+
+func (f *fakeEmbedder) MaxEmbedBatchSize() int {
+	return 10
+}
+
+func (f *fakeEmbedder) Embed(texts []string) (domain.Embeddings, error) {
+	f.calls++
+	f.texts += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(strings.Count(text, "kubectl")), 1}
+	}
+	return domain.Embeddings{Model: "fake", Dimensions: 2, Vectors: vectors}, nil
+}
+
+func TestChunkText(t *testing.T) {
+	lines := make([]string, 130)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+
+	chunks := ChunkText(strings.Join(lines, "\n"))
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if chunks[0].StartLine != 1 || chunks[0].EndLine != 60 {
+		t.Errorf("unexpected first chunk: %d-%d", chunks[0].StartLine, chunks[0].EndLine)
+	}
+	if chunks[1].StartLine != 56 {
+		t.Errorf("expected chunks to overlap, second chunk starts at %d", chunks[1].StartLine)
+	}
+	if chunks[2].EndLine != 130 || !strings.HasSuffix(chunks[2].Text, "line 130") {
+		t.Errorf("unexpected last chunk: %d-%d", chunks[2].StartLine, chunks[2].EndLine)
+	}
+}
+
+func TestUpdateIsIncremental(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.md"), "how to use kubectl")
+	writeFile(t, filepath.Join(root, "sub", "b.txt"), "nothing relevant")
+	writeFile(t, filepath.Join(root, "image.bin"), "\x00\x01\x02")
+
+	embedder := &fakeEmbedder{}
+	idx, err := Load(root)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	stats, err := idx.Update(embedder)
+	if err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if stats.Added != 2 || stats.EmbeddedChunks != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if err := idx.Store(); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	idx, _ = Load(root)
+	writeFile(t, filepath.Join(root, "sub", "b.txt"), "kubectl kubectl")
+	stats, err = idx.Update(embedder)
+	if err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if stats.Changed != 1 || stats.Unchanged != 1 || stats.EmbeddedChunks != 1 {
+		t.Errorf("unexpected stats after changing one file: %+v", stats)
+	}
+
+	results := idx.Retrieve([]float32{1, 0}, 1)
+	if len(results) != 1 || results[0].Path != "sub/b.txt" || results[0].StartLine != 1 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"os"
+	"strings"
+)
+
+// Schema is a JSON schema used to ask for, and validate, structured answers
+type Schema struct {
+	Path     string
+	Raw      map[string]any
+	compiled *jsonschema.Schema
+}
+
+func Load(path string) (Schema, error) {
+	schemaBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to read schema file: %w", err)
+	}
+
+	var raw map[string]any
+	err = json.Unmarshal(schemaBytes, &raw)
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to parse schema file %s: %w", path, err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	err = compiler.AddResource(path, strings.NewReader(string(schemaBytes)))
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to add schema %s: %w", path, err)
+	}
+	compiled, err := compiler.Compile(path)
+	if err != nil {
+		return Schema{}, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+
+	return Schema{
+		Path:     path,
+		Raw:      raw,
+		compiled: compiled,
+	}, nil
+}
+
+// Validate checks that the answer is a JSON document matching the schema, and returns the document.
+// Markdown code fences and surrounding prose, which some models add anyway, are stripped.
+func (s Schema) Validate(answer string) (string, error) {
+	document := ExtractJson(answer)
+
+	var value any
+	err := json.Unmarshal([]byte(document), &value)
+	if err != nil {
+		return "", fmt.Errorf("answer is not valid JSON: %w", err)
+	}
+
+	err = s.compiled.Validate(value)
+	if err != nil {
+		return "", fmt.Errorf("answer does not match the schema: %#v", err)
+	}
+
+	return document, nil
+}
+
+// ExtractJson returns the JSON document in an answer, without code fences or surrounding text
+func ExtractJson(answer string) string {
+	answer = strings.TrimSpace(answer)
+
+	if strings.HasPrefix(answer, "```") {
+		_, answer, _ = strings.Cut(answer, "\n") // drop the ```json line
+		answer = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(answer), "```"))
+	}
+
+	start := strings.IndexAny(answer, "{[")
+	end := strings.LastIndexAny(answer, "}]")
+	if start >= 0 && end > start {
+		return answer[start : end+1]
+	}
+
+	return answer
+}
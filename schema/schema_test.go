@@ -0,0 +1,70 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSchema = `{
+  "type": "object",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer", "minimum": 0}
+  },
+  "required": ["name", "age"]
+}`
+
+func loadTestSchema(t *testing.T) Schema {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	err := os.WriteFile(path, []byte(testSchema), 0644)
+	if err != nil {
+		t.Fatalf("failed to write schema: %v", err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	s := loadTestSchema(t)
+
+	document, err := s.Validate(`{"name": "bob", "age": 42}`)
+	if err != nil {
+		t.Errorf("expected valid document, got %v", err)
+	}
+	if document != `{"name": "bob", "age": 42}` {
+		t.Errorf("Validate() = %s", document)
+	}
+
+	_, err = s.Validate(`{"name": "bob", "age": -1}`)
+	if err == nil {
+		t.Errorf("expected negative age to fail validation")
+	}
+
+	_, err = s.Validate(`{"name": "bob"`)
+	if err == nil {
+		t.Errorf("expected broken json to fail validation")
+	}
+}
+
+func TestValidateStripsCodeFences(t *testing.T) {
+	s := loadTestSchema(t)
+
+	document, err := s.Validate("```json\n{\"name\": \"bob\", \"age\": 42}\n```")
+	if err != nil {
+		t.Fatalf("expected fenced document to be valid, got %v", err)
+	}
+	if document != `{"name": "bob", "age": 42}` {
+		t.Errorf("Validate() = %s", document)
+	}
+}
+
+func TestExtractJson(t *testing.T) {
+	got := ExtractJson(`Here you go: {"a": [1, 2]} hope it helps`)
+	if got != `{"a": [1, 2]}` {
+		t.Errorf("ExtractJson() = %s", got)
+	}
+}
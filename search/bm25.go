@@ -0,0 +1,56 @@
+package search
+
+import "math"
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25 scores each document (given as its tokens) against the query tokens
+func BM25(query []string, docs [][]string) []float64 {
+	scores := make([]float64, len(docs))
+	if len(docs) == 0 || len(query) == 0 {
+		return scores
+	}
+
+	totalLen := 0
+	docFreq := map[string]int{}
+	termFreqs := make([]map[string]int, len(docs))
+	for i, doc := range docs {
+		totalLen += len(doc)
+		termFreqs[i] = map[string]int{}
+		for _, token := range doc {
+			termFreqs[i][token]++
+		}
+		for token := range termFreqs[i] {
+			docFreq[token]++
+		}
+	}
+	avgLen := float64(totalLen) / float64(len(docs))
+
+	seen := map[string]bool{}
+	for _, term := range query {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		n := float64(docFreq[term])
+		if n == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(len(docs))-n+0.5)/(n+0.5))
+
+		for i, doc := range docs {
+			tf := float64(termFreqs[i][term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(len(doc))/avgLen
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	return scores
+}
@@ -0,0 +1,229 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GrepIndex is a trigram inverted index over session message texts (lower-cased).
+// It only narrows down which sessions can match a regex, the regex itself is always
+// run against the actual session contents.
+type GrepIndex struct {
+	Sessions map[string]time.Time `json:"sessions"` // session id -> updated_at when indexed
+	Postings map[string][]string  `json:"postings"` // trigram -> sorted session ids
+}
+
+type MatchLine struct {
+	LineNo  int // 1-based line number within the message
+	Text    string
+	IsMatch bool // false for context lines
+}
+
+func GrepIndexFile() string {
+	return Dir() + "/grep.json"
+}
+
+func LoadGrepIndex() (GrepIndex, error) {
+	idx, err := util.ReadFileAsJson[GrepIndex](GrepIndexFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return GrepIndex{Sessions: map[string]time.Time{}, Postings: map[string][]string{}}, nil
+		}
+		return GrepIndex{}, fmt.Errorf("failed to read grep index: %w", err)
+	}
+	if idx.Sessions == nil {
+		idx.Sessions = map[string]time.Time{}
+	}
+	if idx.Postings == nil {
+		idx.Postings = map[string][]string{}
+	}
+	return idx, nil
+}
+
+func (g *GrepIndex) Store() error {
+	bytes, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("failed to marshal grep index: %w", err)
+	}
+	return util.WriteFileAtomic(GrepIndexFile(), bytes, 0644)
+}
+
+// Sync re-indexes sessions updated since they were last indexed, and drops deleted ones.
+// Returns whether anything changed.
+func (g *GrepIndex) Sync(headers []session.Header, load func(sessionID string) session.State) bool {
+	stale := map[string]bool{}
+	present := map[string]bool{}
+	for _, header := range headers {
+		present[header.SessionID] = true
+		indexedAt, ok := g.Sessions[header.SessionID]
+		if !ok || !indexedAt.Equal(header.UpdatedAt) {
+			stale[header.SessionID] = ok
+		}
+	}
+	removed := map[string]bool{}
+	for sessionID := range g.Sessions {
+		if !present[sessionID] {
+			removed[sessionID] = true
+		}
+	}
+	if len(stale) == 0 && len(removed) == 0 {
+		return false
+	}
+
+	// one pass over the postings removes all sessions that are re-indexed or deleted
+	for sessionID, wasIndexed := range stale {
+		if wasIndexed {
+			removed[sessionID] = true
+		}
+	}
+	if len(removed) > 0 {
+		for trigram, sessionIDs := range g.Postings {
+			sessionIDs = slices.DeleteFunc(sessionIDs, func(id string) bool { return removed[id] })
+			if len(sessionIDs) == 0 {
+				delete(g.Postings, trigram)
+			} else {
+				g.Postings[trigram] = sessionIDs
+			}
+		}
+		for sessionID := range removed {
+			delete(g.Sessions, sessionID)
+		}
+	}
+
+	for _, header := range headers {
+		if _, ok := stale[header.SessionID]; !ok {
+			continue
+		}
+		state := load(header.SessionID)
+		trigrams := map[string]bool{}
+		for _, entry := range state.History {
+			for _, trigram := range Trigrams(entry.Message.Content) {
+				trigrams[trigram] = true
+			}
+		}
+		for trigram := range trigrams {
+			sessionIDs := g.Postings[trigram]
+			pos, found := slices.BinarySearch(sessionIDs, header.SessionID)
+			if !found {
+				g.Postings[trigram] = slices.Insert(sessionIDs, pos, header.SessionID)
+			}
+		}
+		g.Sessions[header.SessionID] = header.UpdatedAt
+	}
+
+	return true
+}
+
+// Candidates returns the indexed sessions that may match the regex. Sessions are only
+// excluded when the regex requires a literal of at least 3 characters they don't contain.
+func (g *GrepIndex) Candidates(pattern string) ([]string, error) {
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+
+	var candidates map[string]bool
+	for _, literal := range RequiredLiterals(parsed.Simplify()) {
+		for _, trigram := range Trigrams(literal) {
+			next := map[string]bool{}
+			for _, sessionID := range g.Postings[trigram] {
+				if candidates == nil || candidates[sessionID] {
+					next[sessionID] = true
+				}
+			}
+			candidates = next
+		}
+	}
+
+	var result []string
+	for sessionID := range g.Sessions {
+		if candidates == nil || candidates[sessionID] {
+			result = append(result, sessionID)
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+// RequiredLiterals returns literal strings that any match of the regex must contain
+func RequiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return RequiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return RequiredLiterals(re.Sub[0])
+		}
+		return nil
+	case syntax.OpConcat:
+		var result []string
+		run := strings.Builder{}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run.WriteString(string(sub.Rune))
+				continue
+			}
+			if run.Len() > 0 {
+				result = append(result, run.String())
+				run.Reset()
+			}
+			result = append(result, RequiredLiterals(sub)...)
+		}
+		if run.Len() > 0 {
+			result = append(result, run.String())
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// Trigrams returns the distinct lower-cased rune trigrams of text
+func Trigrams(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	seen := map[string]bool{}
+	var result []string
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
+
+// GrepText returns the lines of text matching re, with up to contextLines lines of
+// context around each match. Overlapping context is merged.
+func GrepText(re *regexp.Regexp, text string, contextLines int) []MatchLine {
+	lines := strings.Split(text, "\n")
+	include := make([]bool, len(lines))
+	isMatch := make([]bool, len(lines))
+	for i, line := range lines {
+		if re.MatchString(line) {
+			isMatch[i] = true
+			for j := max(0, i-contextLines); j <= min(len(lines)-1, i+contextLines); j++ {
+				include[j] = true
+			}
+		}
+	}
+
+	var result []MatchLine
+	for i, line := range lines {
+		if include[i] {
+			result = append(result, MatchLine{LineNo: i + 1, Text: line, IsMatch: isMatch[i]})
+		}
+	}
+	return result
+}
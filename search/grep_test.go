@@ -0,0 +1,88 @@
+package search
+
+import (
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	cases := map[string][]string{
+		`kubectl (get|describe) pods`: {"kubectl ", " pods"},
+		`foo.*bar`:                    {"foo", "bar"},
+		`(abc)?def`:                   {"def"},
+		`a|b`:                         nil,
+	}
+	for pattern, want := range cases {
+		parsed, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", pattern, err)
+		}
+		got := RequiredLiterals(parsed.Simplify())
+		if !slices.Equal(got, want) {
+			t.Errorf("RequiredLiterals(%s) = %q; want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestGrepIndexCandidates(t *testing.T) {
+	states := map[string]session.State{
+		"k8s":    {History: []session.HistoryEntry{{Message: domain.Message{SourceType: domain.User, Content: "kubectl get pods"}}}},
+		"france": {History: []session.HistoryEntry{{Message: domain.Message{SourceType: domain.User, Content: "capital of France"}}}},
+	}
+	headers := []session.Header{
+		{SessionID: "k8s", UpdatedAt: time.Unix(1, 0)},
+		{SessionID: "france", UpdatedAt: time.Unix(1, 0)},
+	}
+	load := func(sessionID string) session.State { return states[sessionID] }
+
+	idx := GrepIndex{Sessions: map[string]time.Time{}, Postings: map[string][]string{}}
+	idx.Sync(headers, load)
+
+	got, err := idx.Candidates(`KUBECTL\s+get`)
+	if err != nil {
+		t.Fatalf("Candidates() failed: %v", err)
+	}
+	if !slices.Equal(got, []string{"k8s"}) {
+		t.Errorf("Candidates() = %v; want [k8s]", got)
+	}
+
+	got, _ = idx.Candidates(`.*`)
+	if len(got) != 2 {
+		t.Errorf("expected a regex without literals to match all sessions, got %v", got)
+	}
+
+	// re-indexing a changed session replaces its trigrams
+	states["france"] = session.State{History: []session.HistoryEntry{{Message: domain.Message{SourceType: domain.User, Content: "kubectl logs"}}}}
+	headers[1].UpdatedAt = time.Unix(2, 0)
+	idx.Sync(headers, load)
+
+	got, _ = idx.Candidates(`kubectl`)
+	if !slices.Equal(got, []string{"france", "k8s"}) {
+		t.Errorf("Candidates() = %v; want [france k8s]", got)
+	}
+	got, _ = idx.Candidates(`capital`)
+	if len(got) != 0 {
+		t.Errorf("expected no candidates for removed content, got %v", got)
+	}
+}
+
+func TestGrepText(t *testing.T) {
+	text := "one\ntwo\nkubectl apply\nthree\nfour\nfive\nkubectl delete"
+	got := GrepText(regexp.MustCompile(`kubectl`), text, 1)
+
+	lineNos := make([]int, len(got))
+	for i, line := range got {
+		lineNos[i] = line.LineNo
+	}
+	if !slices.Equal(lineNos, []int{2, 3, 4, 6, 7}) {
+		t.Errorf("line numbers = %v; want [2 3 4 6 7]", lineNos)
+	}
+	if !got[1].IsMatch || got[0].IsMatch {
+		t.Errorf("unexpected match flags: %+v", got)
+	}
+}
@@ -0,0 +1,343 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxEmbedChars caps how much of a message is embedded, to stay within embedding model input limits
+const maxEmbedChars = 8000
+
+type Message struct {
+	MessageIndex int               `json:"message_index"`
+	Role         domain.SourceType `json:"role"`
+	Hash         string            `json:"hash"`
+	Text         string            `json:"text"`
+	Vector       []float32         `json:"vector,omitempty"`
+}
+
+type SessionIndex struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Messages  []Message `json:"messages"`
+}
+
+// Index is the search index of all stored session messages. Vectors are only present
+// when an embedder has been used, and are all from the same EmbeddingModel.
+type Index struct {
+	EmbeddingModel string                  `json:"embedding_model,omitempty"`
+	Sessions       map[string]SessionIndex `json:"sessions"`
+}
+
+type Hit struct {
+	SessionID    string
+	MessageIndex int
+	Role         domain.SourceType
+	Text         string
+	Score        float64
+}
+
+type SessionHit struct {
+	SessionID string
+	Score     float64 // score of the best matching message
+	Hits      []Hit
+}
+
+func Dir() string {
+	dir := common.AppDir() + "/search"
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to create search index dir: %v", err))
+	}
+	return dir
+}
+
+func IndexFile() string {
+	return Dir() + "/index.json"
+}
+
+func LoadIndex() (Index, error) {
+	idx, err := util.ReadFileAsJson[Index](IndexFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Index{Sessions: map[string]SessionIndex{}}, nil
+		}
+		return Index{}, fmt.Errorf("failed to read search index: %w", err)
+	}
+	if idx.Sessions == nil {
+		idx.Sessions = map[string]SessionIndex{}
+	}
+	return idx, nil
+}
+
+func (idx *Index) Store() error {
+	bytes, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal search index: %w", err)
+	}
+	return util.WriteFileAtomic(IndexFile(), bytes, 0644)
+}
+
+// SyncSessions brings the index in line with the stored sessions. Only sessions updated
+// since they were last indexed are loaded, and vectors of unchanged messages are kept.
+// Returns whether anything changed.
+func (idx *Index) SyncSessions(headers []session.Header, load func(sessionID string) session.State) bool {
+	changed := false
+
+	present := map[string]bool{}
+	for _, header := range headers {
+		present[header.SessionID] = true
+		existing, ok := idx.Sessions[header.SessionID]
+		if ok && existing.UpdatedAt.Equal(header.UpdatedAt) {
+			continue
+		}
+
+		oldVectors := map[string][]float32{}
+		for _, msg := range existing.Messages {
+			if msg.Vector != nil {
+				oldVectors[fmt.Sprintf("%d/%s", msg.MessageIndex, msg.Hash)] = msg.Vector
+			}
+		}
+
+		state := load(header.SessionID)
+		var messages []Message
+		for i, entry := range state.History {
+			text := strings.TrimSpace(entry.Message.Content)
+			if text == "" {
+				continue
+			}
+			hash := session.HashString(text)
+			messages = append(messages, Message{
+				MessageIndex: i,
+				Role:         entry.Message.SourceType,
+				Hash:         hash,
+				Text:         text,
+				Vector:       oldVectors[fmt.Sprintf("%d/%s", i, hash)],
+			})
+		}
+
+		idx.Sessions[header.SessionID] = SessionIndex{
+			UpdatedAt: header.UpdatedAt,
+			Messages:  messages,
+		}
+		changed = true
+	}
+
+	for sessionID := range idx.Sessions {
+		if !present[sessionID] {
+			delete(idx.Sessions, sessionID)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// EmbedMissing embeds all messages that don't have a vector yet. If the embedding
+// model changed since the index was built, all vectors are recomputed.
+// Returns the number of embedded messages.
+func (idx *Index) EmbedMissing(embedder domain.Embedder, model string) (int, error) {
+	if model != idx.EmbeddingModel {
+		for sessionID, sessionIndex := range idx.Sessions {
+			for i := range sessionIndex.Messages {
+				sessionIndex.Messages[i].Vector = nil
+			}
+			idx.Sessions[sessionID] = sessionIndex
+		}
+		idx.EmbeddingModel = model
+	}
+
+	var missing []*Message
+	for _, sessionIndex := range idx.Sessions {
+		for i := range sessionIndex.Messages {
+			if sessionIndex.Messages[i].Vector == nil {
+				missing = append(missing, &sessionIndex.Messages[i])
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	texts := make([]string, len(missing))
+	for i, msg := range missing {
+		texts[i] = truncate(msg.Text, maxEmbedChars)
+	}
+
+	res, err := domain.EmbedBatched(embedder, texts, 0)
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range missing {
+		msg.Vector = res.Vectors[i]
+	}
+
+	return len(missing), nil
+}
+
+// SemanticSearch ranks all embedded messages by cosine similarity to the query vector
+func (idx *Index) SemanticSearch(queryVector []float32) []Hit {
+	var hits []Hit
+	for sessionID, sessionIndex := range idx.Sessions {
+		for _, msg := range sessionIndex.Messages {
+			if msg.Vector == nil {
+				continue
+			}
+			hits = append(hits, Hit{
+				SessionID:    sessionID,
+				MessageIndex: msg.MessageIndex,
+				Role:         msg.Role,
+				Text:         msg.Text,
+				Score:        CosineSimilarity(queryVector, msg.Vector),
+			})
+		}
+	}
+	sortHits(hits)
+	return hits
+}
+
+// LexicalSearch ranks all messages with BM25. Messages without any query term are left out.
+func (idx *Index) LexicalSearch(query string) []Hit {
+	var docs []Hit
+	var docTokens [][]string
+	for sessionID, sessionIndex := range idx.Sessions {
+		for _, msg := range sessionIndex.Messages {
+			docs = append(docs, Hit{
+				SessionID:    sessionID,
+				MessageIndex: msg.MessageIndex,
+				Role:         msg.Role,
+				Text:         msg.Text,
+			})
+			docTokens = append(docTokens, Tokenize(msg.Text))
+		}
+	}
+
+	scores := BM25(Tokenize(query), docTokens)
+
+	var hits []Hit
+	for i, score := range scores {
+		if score > 0 {
+			docs[i].Score = score
+			hits = append(hits, docs[i])
+		}
+	}
+	sortHits(hits)
+	return hits
+}
+
+// GroupBySession groups ranked hits per session, keeping at most maxHitsPerSession
+// hits per session. Sessions are ordered by their best hit.
+func GroupBySession(hits []Hit, maxHitsPerSession int) []SessionHit {
+	var result []SessionHit
+	positions := map[string]int{}
+	for _, hit := range hits {
+		pos, ok := positions[hit.SessionID]
+		if !ok {
+			pos = len(result)
+			positions[hit.SessionID] = pos
+			result = append(result, SessionHit{SessionID: hit.SessionID, Score: hit.Score})
+		}
+		if len(result[pos].Hits) < maxHitsPerSession {
+			result[pos].Hits = append(result[pos].Hits, hit)
+		}
+	}
+	return result
+}
+
+func CosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Tokenize lower-cases and splits text on anything that isn't a letter or digit
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Snippet returns a single line excerpt of text of about width runes, centered
+// on the first occurrence of any of the terms (or the start of the text)
+func Snippet(text string, terms []string, width int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := []rune(strings.ToLower(string(runes)))
+
+	matchPos := -1
+	for _, term := range terms {
+		if term == "" {
+			continue
+		}
+		if pos := runeIndex(lower, []rune(strings.ToLower(term))); pos >= 0 && (matchPos < 0 || pos < matchPos) {
+			matchPos = pos
+		}
+	}
+
+	start := 0
+	if matchPos > width/3 {
+		start = matchPos - width/3
+	}
+	end := min(start+width, len(runes))
+
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet = snippet + "..."
+	}
+	return snippet
+}
+
+func runeIndex(haystack []rune, needle []rune) int {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if slices.Equal(haystack[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortHits(hits []Hit) {
+	slices.SortStableFunc(hits, func(a, b Hit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		if a.SessionID != b.SessionID {
+			return strings.Compare(a.SessionID, b.SessionID)
+		}
+		return a.MessageIndex - b.MessageIndex
+	})
+}
+
+func truncate(text string, maxChars int) string {
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	return string(runes[:maxChars])
+}
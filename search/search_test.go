@@ -0,0 +1,85 @@
+package search
+
+import (
+	"github.com/gigurra/ai/domain"
+	"github.com/gigurra/ai/session"
+	"testing"
+	"time"
+)
+
+func TestBM25(t *testing.T) {
+	docs := [][]string{
+		Tokenize("How do I scale a kubectl deployment?"),
+		Tokenize("What is the capital of France?"),
+		Tokenize("kubectl get pods, kubectl logs and kubectl exec"),
+	}
+
+	scores := BM25(Tokenize("kubectl"), docs)
+
+	if scores[1] != 0 {
+		t.Errorf("expected no score for a document without the term, got %v", scores[1])
+	}
+	if scores[2] <= scores[0] {
+		t.Errorf("expected the document with more matches to rank higher, got %v", scores)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	if got := CosineSimilarity([]float32{1, 0}, []float32{2, 0}); got < 0.999 {
+		t.Errorf("parallel vectors = %v; want 1", got)
+	}
+	if got := CosineSimilarity([]float32{1, 0}, []float32{0, 1}); got != 0 {
+		t.Errorf("orthogonal vectors = %v; want 0", got)
+	}
+	if got := CosineSimilarity([]float32{1, 0}, []float32{1, 0, 0}); got != 0 {
+		t.Errorf("mismatched dimensions = %v; want 0", got)
+	}
+}
+
+func TestSyncSessionsKeepsVectorsOfUnchangedMessages(t *testing.T) {
+	states := map[string]session.State{
+		"s1": {History: []session.HistoryEntry{
+			{Message: domain.Message{SourceType: domain.User, Content: "first"}},
+		}},
+	}
+	load := func(sessionID string) session.State { return states[sessionID] }
+
+	idx := Index{Sessions: map[string]SessionIndex{}}
+	idx.SyncSessions([]session.Header{{SessionID: "s1", UpdatedAt: time.Unix(1, 0)}}, load)
+	idx.Sessions["s1"].Messages[0].Vector = []float32{1, 2}
+
+	states["s1"] = session.State{History: []session.HistoryEntry{
+		{Message: domain.Message{SourceType: domain.User, Content: "first"}},
+		{Message: domain.Message{SourceType: domain.Assistant, Content: "second"}},
+	}}
+	changed := idx.SyncSessions([]session.Header{{SessionID: "s1", UpdatedAt: time.Unix(2, 0)}}, load)
+
+	messages := idx.Sessions["s1"].Messages
+	if !changed || len(messages) != 2 {
+		t.Fatalf("expected the session to be re-indexed with 2 messages, got %+v", messages)
+	}
+	if messages[0].Vector == nil {
+		t.Errorf("expected the vector of the unchanged message to be kept")
+	}
+	if messages[1].Vector != nil {
+		t.Errorf("expected the new message to need embedding")
+	}
+
+	if !idx.SyncSessions(nil, load) || len(idx.Sessions) != 0 {
+		t.Errorf("expected deleted sessions to be removed from the index")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "lorem ipsum dolor sit amet,\n consectetur adipiscing elit, kubectl rollout restart deployment/foo"
+
+	got := Snippet(text, []string{"KUBECTL"}, 30)
+	want := "...ing elit, kubectl rollout rest..."
+	if got != want {
+		t.Errorf("Snippet() = %q; want %q", got, want)
+	}
+
+	if got := Snippet("short text", []string{"missing"}, 30); got != "short text" {
+		t.Errorf("Snippet() = %q; want the full text", got)
+	}
+}
@@ -0,0 +1,116 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/util"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	headerFileName  = "header.json"
+	historyFileName = "history.jsonl"
+	// legacyStateFileName is the format before history.jsonl: the header and the whole history
+	// in one file. It is still read, and replaced by history.jsonl the next time the session is stored.
+	legacyStateFileName = "state.json"
+)
+
+// readHistory reads one HistoryEntry per line, stored with the given schema version.
+// A torn last line, left by a crash while appending, is skipped.
+func readHistory(path string, version int) ([]HistoryEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var history []HistoryEntry
+	reader := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		complete := err == nil
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !complete && !json.Valid(line) {
+				slog.Warn(fmt.Sprintf("Skipping incomplete last line of %s", path))
+				return history, nil
+			}
+			entry, err := decodeEntry(line, version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse line %d of %s: %w", lineNo, path, err)
+			}
+			history = append(history, entry)
+		}
+		if !complete {
+			return history, nil
+		}
+	}
+}
+
+func marshalHistory(entries []HistoryEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal history entry: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// appendHistory appends entries in a single write
+func appendHistory(path string, entries []HistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	data, err := marshalHistory(entries)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to append to %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeHistory replaces the whole history atomically
+func writeHistory(path string, entries []HistoryEntry) error {
+	data, err := marshalHistory(entries)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+// endsWithNewline is false if the last append to the file was torn
+func endsWithNewline(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = file.Close() }()
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	if stat.Size() == 0 {
+		return true
+	}
+	last := make([]byte, 1)
+	_, err = file.ReadAt(last, stat.Size()-1)
+	return err == nil && last[0] == '\n'
+}
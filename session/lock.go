@@ -0,0 +1,33 @@
+package session
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"log/slog"
+	"os"
+)
+
+// Lock takes an advisory lock on a session, held until the returned func is called. Lock files
+// are kept outside the sessions dir, so they are never synced.
+func Lock(sessionID string) func() {
+	dir := common.AppDir() + "/locks"
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to create session lock dir: %v", err))
+	}
+	file, err := os.OpenFile(dir+"/"+sessionID+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to open session lock file: %v", err))
+	}
+	err = lockFile(file)
+	if err != nil {
+		_ = file.Close()
+		common.FailAndExit(1, fmt.Sprintf("Failed to lock session %s: %v", sessionID, err))
+	}
+	return func() {
+		if err := unlockFile(file); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to unlock session %s: %v", sessionID, err))
+		}
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/common"
+)
+
+// CurrentSchemaVersion is the schema_version written to session headers. Sessions written
+// before it existed have none, and are version 0.
+const CurrentSchemaVersion = 1
+
+// migration upgrades a session from schema version From to From+1. It works on the decoded
+// JSON rather than on Header and HistoryEntry, so it keeps working as those types change.
+// Either function may be nil.
+type migration struct {
+	From        int
+	Description string
+	Header      func(header map[string]any) error
+	Entry       func(entry map[string]any) error
+}
+
+// migrations are applied in order to sessions older than CurrentSchemaVersion.
+// Adding a version means adding its migration here and bumping CurrentSchemaVersion.
+var migrations = []migration{
+	{From: 0, Description: "add schema_version to the header"},
+}
+
+// SchemaTooNewError is returned for sessions written by a newer version of ai, e.g. synced
+// from another machine. They are left untouched rather than risking losing what we don't know.
+type SchemaTooNewError struct {
+	Version int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("written with schema version %d, but this version of ai only knows up to %d, please upgrade ai", e.Version, CurrentSchemaVersion)
+}
+
+func schemaVersion(header map[string]any) (int, error) {
+	raw, ok := header["schema_version"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	version, ok := raw.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", raw)
+	}
+	if int(version) > CurrentSchemaVersion {
+		return 0, &SchemaTooNewError{Version: int(version)}
+	}
+	return int(version), nil
+}
+
+func migrateHeader(header map[string]any, from int) error {
+	for _, m := range migrations[from:] {
+		if m.Header != nil {
+			if err := m.Header(header); err != nil {
+				return fmt.Errorf("failed to migrate header from schema version %d (%s): %w", m.From, m.Description, err)
+			}
+		}
+	}
+	header["schema_version"] = CurrentSchemaVersion
+	return nil
+}
+
+func migrateEntry(entry map[string]any, from int) error {
+	for _, m := range migrations[from:] {
+		if m.Entry != nil {
+			if err := m.Entry(entry); err != nil {
+				return fmt.Errorf("failed to migrate history entry from schema version %d (%s): %w", m.From, m.Description, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeHeader parses a header of any known schema version, and returns it upgraded along
+// with the version it was stored as
+func decodeHeader(data []byte) (Header, int, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Header{}, 0, err
+	}
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return Header{}, 0, err
+	}
+	if version == CurrentSchemaVersion {
+		var header Header
+		err = json.Unmarshal(data, &header)
+		return header, version, err
+	}
+	if err := migrateHeader(raw, version); err != nil {
+		return Header{}, 0, err
+	}
+	header, err := remarshal[Header](raw)
+	return header, version, err
+}
+
+// decodeEntry parses a history entry stored with the given schema version
+func decodeEntry(data []byte, version int) (HistoryEntry, error) {
+	if version == CurrentSchemaVersion {
+		var entry HistoryEntry
+		err := json.Unmarshal(data, &entry)
+		return entry, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return HistoryEntry{}, err
+	}
+	if err := migrateEntry(raw, version); err != nil {
+		return HistoryEntry{}, err
+	}
+	return remarshal[HistoryEntry](raw)
+}
+
+// decodeState parses a whole state (header and history in one document, as in the legacy
+// state.json and in archives) of any known schema version
+func decodeState(data []byte) (State, int, error) {
+	var raw struct {
+		History []json.RawMessage `json:"history"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return State{}, 0, err
+	}
+	header, version, err := decodeHeader(data)
+	if err != nil {
+		return State{}, 0, err
+	}
+	state := State{Header: header}
+	for i, entryData := range raw.History {
+		entry, err := decodeEntry(entryData, version)
+		if err != nil {
+			return State{}, 0, fmt.Errorf("history entry %d: %w", i, err)
+		}
+		state.History = append(state.History, entry)
+	}
+	return state, version, nil
+}
+
+func remarshal[T any](raw map[string]any) (T, error) {
+	var result T
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// SessionCheck is the result of parsing one stored session and its archives
+type SessionCheck struct {
+	SessionID string
+	Version   int // the schema version it is stored as
+	Entries   int
+	Archives  int
+	Err       error
+}
+
+// CheckSessions parses every stored session, including archived states
+func CheckSessions() []SessionCheck {
+	store := ActiveStore()
+	ids, err := store.IDs()
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to list sessions: %v", err))
+	}
+
+	var result []SessionCheck
+	for _, sessionID := range ids {
+		check := SessionCheck{SessionID: sessionID}
+		state, err := store.Load(sessionID)
+		if err != nil {
+			check.Err = err
+			result = append(result, check)
+			continue
+		}
+		check.Version = state.storedVersion
+		check.Entries = len(state.History)
+		archives, err := store.ListArchives(sessionID)
+		if err != nil {
+			check.Err = err
+		}
+		for _, n := range archives {
+			if _, err := store.LoadArchive(sessionID, n); err != nil {
+				check.Err = fmt.Errorf("archive %d: %w", n, err)
+				break
+			}
+			check.Archives++
+		}
+		result = append(result, check)
+	}
+	return result
+}
+
+// MigrateSession rewrites a stored session in the current schema version, keeping when it was
+// last updated. Sessions are otherwise only upgraded in memory when loaded, and written in the
+// new version when next stored.
+func MigrateSession(sessionID string) {
+	unlock := Lock(sessionID)
+	defer unlock()
+
+	store := ActiveStore()
+	state, err := store.Load(sessionID)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to read session %s: %v", sessionID, err))
+	}
+	state.SchemaVersion = CurrentSchemaVersion
+	if _, err := store.Save(state, 0); err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to store session %s: %v", sessionID, err))
+	}
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMigrationsCoverEveryVersion(t *testing.T) {
+	if len(migrations) != CurrentSchemaVersion {
+		t.Fatalf("expected %d migrations, got %d", CurrentSchemaVersion, len(migrations))
+	}
+	for i, m := range migrations {
+		if m.From != i {
+			t.Errorf("migration %d upgrades from version %d", i, m.From)
+		}
+	}
+}
+
+func TestSessionsAreUpgradedOnLoad(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	sessionDir := Dir() + "/old"
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(sessionDir+"/"+headerFileName, []byte(`{"session_id":"old","updated_at":"2024-01-02T03:04:05Z","input_tokens_accum":3}`), 0644)
+	_ = os.WriteFile(sessionDir+"/"+historyFileName, []byte(`{"type":"message","message":{"content":"hi"}}`+"\n"), 0644)
+
+	state := LoadSession("old")
+	if state.SchemaVersion != CurrentSchemaVersion || state.storedVersion != 0 {
+		t.Errorf("expected version 0 upgraded to %d, got %d (stored %d)", CurrentSchemaVersion, state.SchemaVersion, state.storedVersion)
+	}
+	if len(state.History) != 1 || state.History[0].Message.Content != "hi" || state.InputTokensAccum != 3 {
+		t.Errorf("unexpected upgraded session: %+v", state)
+	}
+
+	MigrateSession("old")
+	checks := CheckSessions()
+	if len(checks) != 1 || checks[0].Err != nil || checks[0].Version != CurrentSchemaVersion || checks[0].Entries != 1 {
+		t.Errorf("expected the migrated session to check out, got %+v", checks)
+	}
+	if updatedAt := LoadSession("old").UpdatedAt; updatedAt.Year() != 2024 {
+		t.Errorf("expected the migration to keep when the session was updated, got %v", updatedAt)
+	}
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	sessionDir := Dir() + "/newer"
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(sessionDir+"/"+headerFileName, []byte(`{"session_id":"newer","schema_version":999}`), 0644)
+
+	_, err := ActiveStore().Load("newer")
+	var tooNew *SchemaTooNewError
+	if !errors.As(err, &tooNew) || tooNew.Version != 999 {
+		t.Errorf("expected a SchemaTooNewError, got %v", err)
+	}
+}
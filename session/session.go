@@ -21,55 +21,99 @@ import (
 	"unicode"
 )
 
+// HistoryEntry is one message of a session. Everything but Type and Message is empty for
+// entries stored before it was recorded, and the response fields are only set on answers.
 type HistoryEntry struct {
-	Type    string         `json:"type"`
-	Message domain.Message `json:"message"`
+	ID         string            `json:"id,omitempty"`
+	Timestamp  time.Time         `json:"timestamp,omitzero"`
+	Type       string            `json:"type"`
+	Message    domain.Message    `json:"message"`
+	Provider   string            `json:"provider,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	Usage      *EntryUsage       `json:"usage,omitempty"`
+	LatencyMs  int64             `json:"latency_ms,omitempty"` // until the whole answer was received
+	StopReason domain.StopReason `json:"stop_reason,omitempty"`
+	Cost       float64           `json:"cost,omitempty"` // USD, of the request that produced this answer
+}
+
+// EntryUsage is the token usage of the request that produced an answer
+type EntryUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 type State struct {
 	Header
 	History   []HistoryEntry `json:"history"`
 	StateFile string         `json:"-"`
+
+	// the schema version the session was read as, older ones are rewritten in full when stored
+	storedVersion int
+
+	// what LoadSession returned, so StoreSession can merge with turns stored by others since
+	base *loadedBase
+}
+
+type loadedBase struct {
+	header      Header
+	historyLen  int
+	historyHash string
+	headHash    string // of all but the last entry, to recognize an answer continued in place
+}
+
+func baseOf(state State) *loadedBase {
+	headLen := max(len(state.History)-1, 0)
+	return &loadedBase{
+		header:      state.Header,
+		historyLen:  len(state.History),
+		historyHash: hashHistory(state.History),
+		headHash:    hashHistory(state.History[:headLen]),
+	}
 }
 
 type Header struct {
-	SessionID         string    `json:"session_id"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
-	InputTokens       int       `json:"input_tokens"`
-	OutputTokens      int       `json:"output_tokens"`
-	InputTokensAccum  int       `json:"input_tokens_accum"`
-	OutputTokensAccum int       `json:"output_tokens_accum"`
+	SchemaVersion     int         `json:"schema_version"`
+	SessionID         string      `json:"session_id"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+	InputTokens       int         `json:"input_tokens"`
+	OutputTokens      int         `json:"output_tokens"`
+	InputTokensAccum  int         `json:"input_tokens_accum"`
+	OutputTokensAccum int         `json:"output_tokens_accum"`
+	CostAccum         float64     `json:"cost_accum,omitempty"`       // USD, for answers with a known price
+	ContextStrategy   string      `json:"context_strategy,omitempty"` // overrides the configured context strategy
+	Compaction        *Compaction `json:"compaction,omitempty"`
 }
 
-func ListSessions() []Header {
-	// list dirs inside session dir
-	// for each dir, read header file
-	sessionDir := Dir()
+// Compaction records the last time older turns were replaced with a summary
+type Compaction struct {
+	At           time.Time `json:"at"`
+	Archive      int       `json:"archive"` // the number of the archive holding the original
+	TokensBefore int       `json:"tokens_before"`
+	TokensAfter  int       `json:"tokens_after"`
+}
 
-	// list dirs inside session dir
-	dirEntries, err := os.ReadDir(sessionDir)
+func ListSessions() []Header {
+	headers, err := ActiveStore().List()
 	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to list session dirs: %v", err))
+		common.FailAndExit(1, fmt.Sprintf("Failed to list sessions: %v", err))
 	}
+	sortByCreatedAtDesc(headers)
+	return headers
+}
 
-	var headers []Header
-	for _, dirEntry := range dirEntries {
-		if !dirEntry.IsDir() {
-			continue // ignore files
-		}
-		if dirEntry.Name() == ".git" {
-			continue // ignore .git dirs
-		}
-		header, err := util.ReadFileAsJson[Header](sessionDir + "/" + dirEntry.Name() + "/header.json")
-		if err != nil {
-			slog.Error(fmt.Sprintf("Failed to read header file: %s, %v", dirEntry.Name(), err))
-			continue
-		}
-
-		headers = append(headers, header)
+// SearchSessions returns the stored sessions matching the query, latest created first
+func SearchSessions(query Query) []Header {
+	headers, err := ActiveStore().Search(query)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to search sessions: %v", err))
 	}
+	sortByCreatedAtDesc(headers)
+	return headers
+}
 
+func sortByCreatedAtDesc(headers []Header) {
 	// sort by created_at desc
 	slices.SortFunc(headers, func(a, b Header) int {
 		if a == b {
@@ -80,71 +124,138 @@ func ListSessions() []Header {
 			return -1
 		}
 	})
-
-	return headers
 }
 
 func StoredSessionExists(sessionID string) bool {
-	sessionDir := Dir() + "/" + sessionID
-	exists, err := util.FileExists(sessionDir)
+	exists, err := ActiveStore().Exists(sessionID)
 	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to check session dir: %v", err))
+		common.FailAndExit(1, fmt.Sprintf("Failed to check session %s: %v", sessionID, err))
 	}
 	return exists
 }
 
 func LoadSession(sessionID string) State {
-	sessionDir := Dir() + "/" + sessionID
-	_, err := util.ReadFileAsJson[Header](sessionDir + "/header.json")
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return State{
-				Header: Header{
-					SessionID: sessionID,
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				},
-			}
-		} else {
-			common.FailAndExit(1, fmt.Sprintf("Failed to read session header: %v", err))
+	state, found := readSession(sessionID)
+	if !found {
+		state = State{
+			Header: Header{
+				SessionID: sessionID,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			},
 		}
+		state.base = &loadedBase{historyHash: hashHistory(nil), headHash: hashHistory(nil)}
+		return state
 	}
 
-	stateFile := sessionDir + "/state.json"
-	state, err := util.ReadFileAsJson[State](stateFile)
+	state.base = baseOf(state)
+	return state
+}
+
+func readSession(sessionID string) (State, bool) {
+	state, err := ActiveStore().Load(sessionID)
 	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to read session state: %v", err))
+		if errors.Is(err, fs.ErrNotExist) {
+			return State{}, false
+		}
+		common.FailAndExit(1, fmt.Sprintf("Failed to read session %s: %v", sessionID, err))
 	}
+	return state, true
+}
 
-	state.StateFile = stateFile
+// StoreSession writes the session to the active store, under the session lock. If the session
+// was stored by someone else since it was loaded, the turns added since are appended to theirs.
+// Only turns not already stored are written. Returns the state as stored.
+func StoreSession(state State) State {
+	unlock := Lock(state.SessionID)
+	defer unlock()
 
-	return state
-}
+	store := ActiveStore()
+	stored, found := readSession(state.SessionID)
+	if found {
+		state = merge(stored, state)
+	}
 
-func StoreSession(state State) {
 	state.UpdatedAt = time.Now()
-	sessionDir := Dir() + "/" + state.SessionID
-	err := os.MkdirAll(sessionDir, 0755)
-	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to create session dir: %v", err))
-	}
+	state.SchemaVersion = CurrentSchemaVersion
 
-	headerBytes, err := json.Marshal(state.Header)
-	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to marshal session header: %v", err))
+	unchanged := 0
+	if found &&
+		stored.storedVersion == CurrentSchemaVersion &&
+		len(state.History) >= len(stored.History) &&
+		hashHistory(state.History[:len(stored.History)]) == hashHistory(stored.History) {
+		unchanged = len(stored.History)
 	}
-	stateBytes, err := json.Marshal(state)
+	location, err := store.Save(state, unchanged)
 	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to marshal session state: %v", err))
+		common.FailAndExit(1, fmt.Sprintf("Failed to store session: %v", err))
 	}
-	err = os.WriteFile(sessionDir+"/header.json", headerBytes, 0644)
-	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to write session header: %v", err))
+
+	state.StateFile = location
+	state.storedVersion = CurrentSchemaVersion
+	state.base = baseOf(state)
+	return state
+}
+
+// merge combines the stored state with ours. If ours only added turns to what was loaded,
+// or continued its last answer (ai continue), that is applied to the stored history, and the
+// token and cost totals are added up. Otherwise (the history was rewritten, e.g. compacted,
+// or the state wasn't loaded from this session) ours replaces the stored state.
+func merge(stored State, ours State) State {
+	base := ours.base
+	if base == nil || (base.header.SessionID != "" && base.header.SessionID != ours.SessionID) {
+		return ours
+	}
+	if stored.UpdatedAt.Equal(base.header.UpdatedAt) && len(stored.History) == base.historyLen {
+		return ours // nobody else stored it since
+	}
+	keptBase := len(ours.History) >= base.historyLen && hashHistory(ours.History[:base.historyLen]) == base.historyHash
+	continued := !keptBase && continuedLastEntry(stored, ours)
+	if !keptBase && !continued {
+		slog.Warn(fmt.Sprintf("Session %s was changed by another ai process, overwriting its changes", ours.SessionID))
+		return ours
+	}
+
+	merged := stored
+	merged.History = append(slices.Clone(stored.History), ours.History[base.historyLen:]...)
+	if continued {
+		merged.History[base.historyLen-1] = ours.History[base.historyLen-1]
+	}
+	merged.InputTokensAccum += ours.InputTokensAccum - base.header.InputTokensAccum
+	merged.OutputTokensAccum += ours.OutputTokensAccum - base.header.OutputTokensAccum
+	merged.CostAccum += ours.CostAccum - base.header.CostAccum
+	if ours.InputTokens != base.header.InputTokens || ours.OutputTokens != base.header.OutputTokens {
+		merged.InputTokens = ours.InputTokens
+		merged.OutputTokens = ours.OutputTokens
+	}
+	if ours.ContextStrategy != base.header.ContextStrategy {
+		merged.ContextStrategy = ours.ContextStrategy
+	}
+	return merged
+}
+
+// continuedLastEntry tells if ours only changed the last entry it was loaded with, e.g. to
+// continue an answer, and that entry is still unchanged in the stored history
+func continuedLastEntry(stored State, ours State) bool {
+	base := ours.base
+	last := base.historyLen - 1
+	return last >= 0 &&
+		len(ours.History) >= base.historyLen &&
+		hashHistory(ours.History[:last]) == base.headHash &&
+		len(stored.History) >= base.historyLen &&
+		hashHistory(stored.History[:base.historyLen]) == base.historyHash &&
+		ours.History[last].ID == stored.History[last].ID
+}
+
+func hashHistory(history []HistoryEntry) string {
+	if len(history) == 0 {
+		history = []HistoryEntry{} // nil and empty hash the same
 	}
-	err = os.WriteFile(sessionDir+"/state.json", stateBytes, 0644)
+	bytes, err := json.Marshal(history)
 	if err != nil {
-		common.FailAndExit(1, fmt.Sprintf("Failed to write session state: %v", err))
+		common.FailAndExit(1, fmt.Sprintf("Failed to marshal session history: %v", err))
 	}
+	return HashString(string(bytes))
 }
 
 func cliCommandExists(cmd string) bool {
@@ -265,14 +376,9 @@ func DeleteSession(sessionID string, yes bool) {
 		}
 	}
 
-	sessionDir := Dir() + "/" + sessionID
-	if util.Must(util.FileExists(sessionDir)) {
-		err := os.RemoveAll(sessionDir)
-		if err != nil {
-			common.FailAndExit(1, fmt.Sprintf("Failed to delete session dir: %v", err))
-		}
-	} else {
-		fmt.Printf("Session not found: %s, nothing to delete\n", sessionID)
+	err := ActiveStore().Delete(sessionID)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to delete session: %v", err))
 	}
 
 	if sessionID == currentSessionID {
@@ -303,10 +409,7 @@ func SetSession(sessionId string) {
 }
 
 func RenameSession(sessionID string, newSessionID string) {
-	old, _ := CopySession(sessionID, newSessionID)
-	if StoredSessionExists(old) {
-		DeleteSession(old, true)
-	}
+	copyOrRenameSession(sessionID, newSessionID, true)
 }
 
 func IsAllowedNameChar(r rune) bool {
@@ -323,6 +426,10 @@ func IsValidSessionName(name string) bool {
 }
 
 func CopySession(sessionID string, newSessionID string) (string, string) {
+	return copyOrRenameSession(sessionID, newSessionID, false)
+}
+
+func copyOrRenameSession(sessionID string, newSessionID string, rename bool) (string, string) {
 	if !IsValidSessionName(newSessionID) {
 		common.FailAndExit(1, fmt.Sprintf("Invalid session name: %s", newSessionID))
 	}
@@ -352,10 +459,20 @@ func CopySession(sessionID string, newSessionID string) (string, string) {
 		common.FailAndExit(1, fmt.Sprintf("Session not found: %s", sessionID))
 	}
 
-	s := LoadSession(sessionID)
-	s.SessionID = newSessionID
+	unlock := Lock(sessionID)
+	defer unlock()
+	unlockNew := Lock(newSessionID)
+	defer unlockNew()
 
-	StoreSession(s)
+	var err error
+	if rename {
+		err = ActiveStore().Rename(sessionID, newSessionID)
+	} else {
+		err = ActiveStore().Copy(sessionID, newSessionID)
+	}
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to copy session %s to %s: %v", sessionID, newSessionID, err))
+	}
 	if sessionID == curSessionID {
 		SetSession(newSessionID)
 	}
@@ -435,11 +552,39 @@ func Dir() string {
 	return res
 }
 
+// NewEntry returns a message entry with a new ID, timestamped now
+func NewEntry(message domain.Message) HistoryEntry {
+	return HistoryEntry{
+		ID:        uuid.NewString(),
+		Timestamp: time.Now(),
+		Type:      "message",
+		Message:   message,
+	}
+}
+
 func (s *State) AddMessage(message domain.Message) {
-	s.History = append(s.History, HistoryEntry{
-		Type:    "message",
-		Message: message,
-	})
+	s.History = append(s.History, NewEntry(message))
+}
+
+// AddAnswer adds an assistant message along with why the model stopped generating it. Returns
+// the entry, so the caller can record what produced the answer.
+func (s *State) AddAnswer(message domain.Message, stopReason domain.StopReason) *HistoryEntry {
+	entry := NewEntry(message)
+	entry.StopReason = stopReason
+	s.History = append(s.History, entry)
+	return &s.History[len(s.History)-1]
+}
+
+// LastAnswer returns the last history entry if it is an assistant message
+func (s *State) LastAnswer() (*HistoryEntry, bool) {
+	if len(s.History) == 0 {
+		return nil, false
+	}
+	last := &s.History[len(s.History)-1]
+	if last.Type != "message" || last.Message.SourceType != domain.Assistant {
+		return nil, false
+	}
+	return last, true
 }
 
 func (s *State) MessageHistory() []domain.Message {
@@ -449,3 +594,47 @@ func (s *State) MessageHistory() []domain.Message {
 		return entry.Message
 	})
 }
+
+// ListArchives returns the numbers of the archived states of a session, in ascending order
+func ListArchives(sessionID string) []int {
+	archives, err := ActiveStore().ListArchives(sessionID)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to list session archives: %v", err))
+	}
+	return archives
+}
+
+// ArchiveState copies the stored state of a session to a new archive, and returns its number
+func ArchiveState(sessionID string) int {
+	archives := ListArchives(sessionID)
+	n := 1
+	if len(archives) > 0 {
+		n = archives[len(archives)-1] + 1
+	}
+
+	state, found := readSession(sessionID)
+	if !found {
+		common.FailAndExit(1, fmt.Sprintf("Session not found: %s", sessionID))
+	}
+	err := ActiveStore().SaveArchive(sessionID, n, state)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to write session archive: %v", err))
+	}
+	return n
+}
+
+// RestoreArchive makes archive n the current state of the session again, and removes the archive
+func RestoreArchive(sessionID string, n int) State {
+	state, err := ActiveStore().LoadArchive(sessionID, n)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to read session archive %d: %v", n, err))
+	}
+	state.SessionID = sessionID // in case the session was renamed since
+	StoreSession(state)
+
+	err = ActiveStore().DeleteArchive(sessionID, n)
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to remove session archive %d: %v", n, err))
+	}
+	return state
+}
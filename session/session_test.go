@@ -1,7 +1,10 @@
 package session
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/gigurra/ai/domain"
+	"os"
 	"testing"
 )
 
@@ -56,3 +59,135 @@ func TestGetSessionID(t *testing.T) {
 		t.Errorf("GetSessionID() = %s; want %s", sessionID1, sessionID2)
 	}
 }
+
+func TestStoreSessionMergesConcurrentTurns(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	first := LoadSession("concurrent")
+	first.AddMessage(domain.Message{SourceType: domain.User, Content: "q1"})
+	first.InputTokensAccum = 10
+	StoreSession(first)
+
+	a := LoadSession("concurrent")
+	b := LoadSession("concurrent")
+	a.AddMessage(domain.Message{SourceType: domain.User, Content: "from a"})
+	a.InputTokensAccum += 5
+	b.AddMessage(domain.Message{SourceType: domain.User, Content: "from b"})
+	b.InputTokensAccum += 7
+	StoreSession(a)
+	StoreSession(b)
+
+	stored := LoadSession("concurrent")
+	if len(stored.History) != 3 || stored.History[1].Message.Content != "from a" || stored.History[2].Message.Content != "from b" {
+		t.Fatalf("expected both turns to be kept, got %+v", stored.History)
+	}
+	if stored.InputTokensAccum != 22 {
+		t.Errorf("expected the token totals to be added up, got %d", stored.InputTokensAccum)
+	}
+
+	// rewriting the history replaces what is stored
+	c := LoadSession("concurrent")
+	c.History = c.History[2:]
+	StoreSession(c)
+	if stored := LoadSession("concurrent"); len(stored.History) != 1 {
+		t.Errorf("expected the rewritten history to replace the stored one, got %+v", stored.History)
+	}
+}
+
+func TestStoreSessionKeepsConcurrentTurnsWhenContinuingAnAnswer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	first := LoadSession("continued")
+	first.AddMessage(domain.Message{SourceType: domain.User, Content: "q1"})
+	first.AddAnswer(domain.Message{SourceType: domain.Assistant, Content: "cut "}, domain.StopReasonMaxTokens)
+	StoreSession(first)
+
+	continuing := LoadSession("continued")
+	other := LoadSession("continued")
+	other.AddMessage(domain.Message{SourceType: domain.User, Content: "q2"})
+	other.OutputTokensAccum += 3
+	StoreSession(other)
+
+	lastAnswer, _ := continuing.LastAnswer()
+	lastAnswer.Message.Content = "cut off"
+	lastAnswer.StopReason = domain.StopReasonEndTurn
+	continuing.OutputTokensAccum += 5
+	StoreSession(continuing)
+
+	stored := LoadSession("continued")
+	if len(stored.History) != 3 || stored.History[1].Message.Content != "cut off" || stored.History[2].Message.Content != "q2" {
+		t.Fatalf("expected the continued answer and the concurrent turn, got %+v", stored.History)
+	}
+	if stored.History[1].StopReason != domain.StopReasonEndTurn || stored.OutputTokensAccum != 8 {
+		t.Errorf("expected the continuation to be applied, got %+v", stored)
+	}
+}
+
+func TestLegacyStateIsMigratedToHistoryFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	legacy := State{Header: Header{SessionID: "legacy"}}
+	legacy.AddMessage(domain.Message{SourceType: domain.User, Content: "old"})
+	sessionDir := Dir() + "/legacy"
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stateBytes, _ := json.Marshal(legacy)
+	headerBytes, _ := json.Marshal(legacy.Header)
+	_ = os.WriteFile(sessionDir+"/"+legacyStateFileName, stateBytes, 0644)
+	_ = os.WriteFile(sessionDir+"/"+headerFileName, headerBytes, 0644)
+
+	state := LoadSession("legacy")
+	if len(state.History) != 1 || state.History[0].Message.Content != "old" {
+		t.Fatalf("expected the legacy history to load, got %+v", state.History)
+	}
+	state.AddMessage(domain.Message{SourceType: domain.User, Content: "new"})
+	StoreSession(state)
+
+	if _, err := os.Stat(sessionDir + "/" + legacyStateFileName); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed after migration", legacyStateFileName)
+	}
+
+	// a torn append is skipped, and the next store rewrites the file
+	f, _ := os.OpenFile(sessionDir+"/"+historyFileName, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString(`{"message":{"content":"to`)
+	_ = f.Close()
+
+	state = LoadSession("legacy")
+	if len(state.History) != 2 || state.History[1].Message.Content != "new" {
+		t.Fatalf("expected the migrated history without the torn line, got %+v", state.History)
+	}
+	state.AddMessage(domain.Message{SourceType: domain.User, Content: "newer"})
+	StoreSession(state)
+	if stored := LoadSession("legacy"); len(stored.History) != 3 || stored.History[2].Message.Content != "newer" {
+		t.Errorf("expected three entries after the rewrite, got %+v", stored.History)
+	}
+}
+
+func TestEntryMetadataRoundTrips(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	old, err := decodeEntry([]byte(`{"type":"message","message":{"SourceType":"assistant","Content":"hi"}}`), CurrentSchemaVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.ID != "" || !old.Timestamp.IsZero() || old.Model != "" || old.Usage != nil || old.LatencyMs != 0 {
+		t.Errorf("expected an old entry to load with empty metadata, got %+v", old)
+	}
+
+	state := LoadSession("meta")
+	answer := state.AddAnswer(domain.Message{SourceType: domain.Assistant, Content: "hi"}, domain.StopReasonEndTurn)
+	answer.Provider = "openai"
+	answer.Model = "gpt-4o"
+	answer.Usage = &EntryUsage{InputTokens: 10, CachedTokens: 2, OutputTokens: 3}
+	answer.LatencyMs = 1500
+	StoreSession(state)
+
+	stored := LoadSession("meta").History[0]
+	if stored.ID == "" || stored.ID != state.History[0].ID || stored.Timestamp.IsZero() {
+		t.Errorf("expected the id and timestamp to be stored, got %+v", stored)
+	}
+	if stored.Model != "gpt-4o" || stored.Usage == nil || stored.Usage.CachedTokens != 2 || stored.LatencyMs != 1500 || stored.StopReason != domain.StopReasonEndTurn {
+		t.Errorf("expected the response metadata to be stored, got %+v", stored)
+	}
+}
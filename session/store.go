@@ -0,0 +1,181 @@
+package session
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/common"
+	"github.com/gigurra/ai/util"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	BackendFS     = "fs"
+	BackendSQLite = "sqlite"
+)
+
+// Store is where sessions are kept. The package level functions (LoadSession, StoreSession,
+// ListSessions, ...) use the active store, and take care of locking and merging concurrent
+// turns, so backends only read and write what they are given.
+type Store interface {
+	Name() string
+
+	// IDs returns the ids of all stored sessions, including ones that fail to parse
+	IDs() ([]string, error)
+	List() ([]Header, error)
+	Exists(sessionID string) (bool, error)
+	// Load returns the session upgraded to the current schema version, or fs.ErrNotExist
+	Load(sessionID string) (State, error)
+	// Save stores the session. The first unchanged entries of its history are already stored
+	// as they are, so only the rest needs to be written. Returns where it was stored.
+	Save(state State, unchanged int) (string, error)
+	Delete(sessionID string) error
+	Copy(sessionID string, newSessionID string) error
+	Rename(sessionID string, newSessionID string) error
+	// Search returns the headers of the sessions matching the query, in no particular order
+	Search(query Query) ([]Header, error)
+
+	// ListArchives returns the numbers of the archived states of a session, in ascending order
+	ListArchives(sessionID string) ([]int, error)
+	LoadArchive(sessionID string, n int) (State, error)
+	SaveArchive(sessionID string, n int, state State) error
+	DeleteArchive(sessionID string, n int) error
+}
+
+// Query selects stored sessions. Zero fields match everything.
+type Query struct {
+	Text  string    // a substring of any message, case insensitive
+	Since time.Time // updated on or after
+	Until time.Time // created before
+}
+
+func (q Query) matchesHeader(header Header) bool {
+	if !q.Since.IsZero() && header.UpdatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !header.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// SQLiteFile is the database of the sqlite backend. The backend is active when it exists.
+func SQLiteFile() string {
+	return common.AppDir() + "/sessions.db"
+}
+
+// ActiveStore returns the store sessions are kept in: the sqlite database if there is one,
+// otherwise the session dirs
+func ActiveStore() Store {
+	if util.Must(util.FileExists(SQLiteFile())) {
+		return openStore(BackendSQLite)
+	}
+	return fsStore{}
+}
+
+func openStore(backend string) Store {
+	switch backend {
+	case BackendFS:
+		return fsStore{}
+	case BackendSQLite:
+		store, err := openSQLiteStore(SQLiteFile())
+		if err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to open session database: %v", err))
+		}
+		return store
+	default:
+		common.FailAndExit(1, fmt.Sprintf("Unknown session storage backend: %s", backend))
+		return nil
+	}
+}
+
+// MigrateStorage moves all sessions, with their archived states, from the active store to
+// the given backend, which is active afterwards. Returns the number of sessions moved.
+func MigrateStorage(to string) int {
+	from := ActiveStore()
+	if from.Name() == to {
+		common.FailAndExit(1, fmt.Sprintf("Sessions are already stored in %s", to))
+	}
+
+	ids, err := from.IDs()
+	if err != nil {
+		common.FailAndExit(1, fmt.Sprintf("Failed to list sessions: %v", err))
+	}
+
+	var target Store
+	switch to {
+	case BackendSQLite:
+		// built next to the real database, which activates it once complete
+		target, err = openSQLiteStore(SQLiteFile() + ".tmp")
+		if err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to create session database: %v", err))
+		}
+	default:
+		target = openStore(to)
+	}
+
+	for _, sessionID := range ids {
+		err := copySessionTo(from, target, sessionID)
+		if err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to migrate session %s, nothing was removed: %v", sessionID, err))
+		}
+	}
+
+	closeStore(from)
+	closeStore(target)
+	switch to {
+	case BackendSQLite:
+		err = os.Rename(SQLiteFile()+".tmp", SQLiteFile())
+		if err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to activate session database: %v", err))
+		}
+		for _, sessionID := range ids {
+			if err := removeSessionFiles(sessionID); err != nil {
+				slog.Warn(fmt.Sprintf("Failed to remove the migrated files of session %s: %v", sessionID, err))
+			}
+		}
+	case BackendFS:
+		err = os.Remove(SQLiteFile())
+		if err != nil {
+			common.FailAndExit(1, fmt.Sprintf("Failed to remove the migrated session database: %v", err))
+		}
+	}
+	return len(ids)
+}
+
+func copySessionTo(from Store, to Store, sessionID string) error {
+	unlock := Lock(sessionID)
+	defer unlock()
+
+	state, err := from.Load(sessionID)
+	if err != nil {
+		return err
+	}
+	state.SchemaVersion = CurrentSchemaVersion
+	if _, err := to.Save(state, 0); err != nil {
+		return err
+	}
+	archives, err := from.ListArchives(sessionID)
+	if err != nil {
+		return err
+	}
+	for _, n := range archives {
+		archive, err := from.LoadArchive(sessionID, n)
+		if err != nil {
+			return fmt.Errorf("archive %d: %w", n, err)
+		}
+		if err := to.SaveArchive(sessionID, n, archive); err != nil {
+			return fmt.Errorf("archive %d: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func closeStore(store Store) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to close %s session store: %v", store.Name(), err))
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// fsStore keeps each session in a dir of its own under Dir(), which can be synced with git
+type fsStore struct{}
+
+func (fsStore) Name() string {
+	return BackendFS
+}
+
+func (fsStore) IDs() ([]string, error) {
+	dirEntries, err := os.ReadDir(Dir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session dirs: %w", err)
+	}
+
+	var result []string
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue // ignore files
+		}
+		if dirEntry.Name() == ".git" {
+			continue // ignore .git dirs
+		}
+		// dirs without a header only hold e.g. wire logs of a session never stored
+		if exists, _ := (fsStore{}).Exists(dirEntry.Name()); !exists {
+			continue
+		}
+		result = append(result, dirEntry.Name())
+	}
+	return result, nil
+}
+
+func (s fsStore) List() ([]Header, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []Header
+	for _, sessionID := range ids {
+		header, err := readHeader(Dir() + "/" + sessionID)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read header file: %s, %v", sessionID, err))
+			continue
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// Exists is false for dirs without a header, which only hold e.g. wire logs
+func (fsStore) Exists(sessionID string) (bool, error) {
+	return util.FileExists(Dir() + "/" + sessionID + "/" + headerFileName)
+}
+
+func readHeader(sessionDir string) (Header, error) {
+	data, err := os.ReadFile(sessionDir + "/" + headerFileName)
+	if err != nil {
+		return Header{}, err
+	}
+	header, _, err := decodeHeader(data)
+	return header, err
+}
+
+func (fsStore) Load(sessionID string) (State, error) {
+	sessionDir := Dir() + "/" + sessionID
+	headerBytes, err := os.ReadFile(sessionDir + "/" + headerFileName)
+	if err != nil {
+		return State{}, err
+	}
+	header, version, err := decodeHeader(headerBytes)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to parse %s: %w", headerFileName, err)
+	}
+
+	historyFile := sessionDir + "/" + historyFileName
+	history, err := readHistory(historyFile, version)
+	if err == nil {
+		return State{Header: header, History: history, StateFile: historyFile, storedVersion: version}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return State{}, err
+	}
+
+	// sessions stored before history.jsonl
+	stateFile := sessionDir + "/" + legacyStateFileName
+	stateBytes, err := os.ReadFile(stateFile)
+	if err != nil {
+		return State{}, fmt.Errorf("found neither %s nor %s: %w", historyFileName, legacyStateFileName, err)
+	}
+	state, version, err := decodeState(stateBytes)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to parse %s: %w", legacyStateFileName, err)
+	}
+	state.StateFile = stateFile
+	state.storedVersion = version
+	return state, nil
+}
+
+// Save appends the changed entries to history.jsonl, or rewrites it atomically if the last
+// append was torn. The header is written last, since a session without one doesn't exist.
+func (fsStore) Save(state State, unchanged int) (string, error) {
+	sessionDir := Dir() + "/" + state.SessionID
+	err := os.MkdirAll(sessionDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session dir: %w", err)
+	}
+
+	historyFile := sessionDir + "/" + historyFileName
+	if unchanged > 0 && endsWithNewline(historyFile) {
+		err = appendHistory(historyFile, state.History[unchanged:])
+	} else {
+		err = writeHistory(historyFile, state.History)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write session history: %w", err)
+	}
+
+	headerBytes, err := json.Marshal(state.Header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal session header: %w", err)
+	}
+	err = util.WriteFileAtomic(sessionDir+"/"+headerFileName, headerBytes, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write session header: %w", err)
+	}
+
+	// sessions stored before history.jsonl are migrated by now
+	err = os.Remove(sessionDir + "/" + legacyStateFileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn(fmt.Sprintf("Failed to remove the migrated %s: %v", legacyStateFileName, err))
+	}
+	return historyFile, nil
+}
+
+func (fsStore) Delete(sessionID string) error {
+	return os.RemoveAll(Dir() + "/" + sessionID)
+}
+
+func (s fsStore) Copy(sessionID string, newSessionID string) error {
+	state, err := s.Load(sessionID)
+	if err != nil {
+		return err
+	}
+	state.SessionID = newSessionID
+	state.SchemaVersion = CurrentSchemaVersion
+	_, err = s.Save(state, 0)
+	return err
+}
+
+// Rename moves the whole session dir, so archives and wire logs are kept
+func (s fsStore) Rename(sessionID string, newSessionID string) error {
+	newDir := Dir() + "/" + newSessionID
+	if exists, err := s.Exists(newSessionID); err != nil || exists {
+		return fmt.Errorf("session already exists: %s", newSessionID)
+	}
+	err := moveDir(Dir()+"/"+sessionID, newDir)
+	if err != nil {
+		return err
+	}
+	headerBytes, err := os.ReadFile(newDir + "/" + headerFileName)
+	if err != nil {
+		return err
+	}
+	headerBytes, err = renameHeader(headerBytes, newSessionID)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(newDir+"/"+headerFileName, headerBytes, 0644)
+}
+
+// moveDir renames from to to, or moves its contents into to if that already exists, e.g.
+// with wire logs of a session never stored under that name
+func moveDir(from string, to string) error {
+	exists, err := util.FileExists(to)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return os.Rename(from, to)
+	}
+	dirEntries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			err = moveDir(from+"/"+dirEntry.Name(), to+"/"+dirEntry.Name())
+		} else {
+			err = os.Rename(from+"/"+dirEntry.Name(), to+"/"+dirEntry.Name())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(from)
+}
+
+// renameHeader changes the session id of a stored header, leaving the rest as it was
+// stored, in whatever schema version
+func renameHeader(headerBytes []byte, newSessionID string) ([]byte, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(headerBytes, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", headerFileName, err)
+	}
+	raw["session_id"] = newSessionID
+	return json.Marshal(raw)
+}
+
+// Search loads every session that matches the dates of the query, there is no index
+func (s fsStore) Search(query Query) ([]Header, error) {
+	headers, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.ToLower(query.Text)
+	var result []Header
+	for _, header := range headers {
+		if !query.matchesHeader(header) {
+			continue
+		}
+		if text != "" {
+			state, err := s.Load(header.SessionID)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to read session %s: %v", header.SessionID, err))
+				continue
+			}
+			if !containsText(state.History, text) {
+				continue
+			}
+		}
+		result = append(result, header)
+	}
+	return result, nil
+}
+
+func containsText(history []HistoryEntry, lowerText string) bool {
+	for _, entry := range history {
+		if strings.Contains(strings.ToLower(entry.Message.Content), lowerText) {
+			return true
+		}
+	}
+	return false
+}
+
+func archiveFile(sessionID string, n int) string {
+	return fmt.Sprintf("%s/%s/state.%d.json", Dir(), sessionID, n)
+}
+
+func archiveNumber(fileName string) (int, bool) {
+	var n int
+	_, err := fmt.Sscanf(fileName, "state.%d.json", &n)
+	return n, err == nil && fileName == fmt.Sprintf("state.%d.json", n)
+}
+
+func (fsStore) ListArchives(sessionID string) ([]int, error) {
+	dirEntries, err := os.ReadDir(Dir() + "/" + sessionID)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list session dir: %w", err)
+	}
+
+	var result []int
+	for _, dirEntry := range dirEntries {
+		if n, ok := archiveNumber(dirEntry.Name()); ok {
+			result = append(result, n)
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+func (fsStore) LoadArchive(sessionID string, n int) (State, error) {
+	data, err := os.ReadFile(archiveFile(sessionID, n))
+	if err != nil {
+		return State{}, err
+	}
+	state, _, err := decodeState(data)
+	return state, err
+}
+
+func (fsStore) SaveArchive(sessionID string, n int, state State) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session state: %w", err)
+	}
+	return util.WriteFileAtomic(archiveFile(sessionID, n), stateBytes, 0644)
+}
+
+func (fsStore) DeleteArchive(sessionID string, n int) error {
+	return os.Remove(archiveFile(sessionID, n))
+}
+
+// removeSessionFiles removes what fsStore keeps of a session after it was moved to another
+// store, leaving e.g. wire logs. The dir is removed if nothing else is left.
+func removeSessionFiles(sessionID string) error {
+	sessionDir := Dir() + "/" + sessionID
+	dirEntries, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		_, isArchive := archiveNumber(name)
+		if name == headerFileName || name == historyFileName || name == legacyStateFileName || isArchive {
+			if err := os.Remove(sessionDir + "/" + name); err != nil {
+				return err
+			}
+		}
+	}
+	// fails, as intended, if anything else is left
+	_ = os.Remove(sessionDir)
+	return nil
+}
@@ -0,0 +1,357 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/ai/util"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore keeps all sessions in one database, so listing and searching doesn't need to
+// read every session. Wire logs and caches are still kept in the session dirs.
+type sqliteStore struct {
+	path string
+	db   *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	header     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_updated_at ON sessions (updated_at);
+CREATE TABLE IF NOT EXISTS entries (
+	session_id TEXT NOT NULL,
+	seq        INTEGER NOT NULL,
+	role       TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	entry      TEXT NOT NULL,
+	PRIMARY KEY (session_id, seq)
+);
+CREATE TABLE IF NOT EXISTS archives (
+	session_id TEXT NOT NULL,
+	n          INTEGER NOT NULL,
+	state      TEXT NOT NULL,
+	PRIMARY KEY (session_id, n)
+);
+`
+
+var (
+	sqliteStoresMutex sync.Mutex
+	sqliteStores      = map[string]*sqliteStore{}
+)
+
+// openSQLiteStore opens, or creates, the database at path. Stores are kept open until closed.
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	sqliteStoresMutex.Lock()
+	defer sqliteStoresMutex.Unlock()
+	if store, ok := sqliteStores[path]; ok {
+		return store, nil
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create the schema of %s: %w", path, err)
+	}
+	store := &sqliteStore{path: path, db: db}
+	sqliteStores[path] = store
+	return store, nil
+}
+
+func (s *sqliteStore) Close() error {
+	sqliteStoresMutex.Lock()
+	defer sqliteStoresMutex.Unlock()
+	delete(sqliteStores, s.path)
+	return s.db.Close()
+}
+
+func (s *sqliteStore) Name() string {
+	return BackendSQLite
+}
+
+func (s *sqliteStore) IDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM sessions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqliteStore) List() ([]Header, error) {
+	return s.Search(Query{})
+}
+
+func (s *sqliteStore) Exists(sessionID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE id = ?`, sessionID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqliteStore) Load(sessionID string) (State, error) {
+	var headerJson string
+	err := s.db.QueryRow(`SELECT header FROM sessions WHERE id = ?`, sessionID).Scan(&headerJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, fs.ErrNotExist
+	}
+	if err != nil {
+		return State{}, err
+	}
+	header, version, err := decodeHeader([]byte(headerJson))
+	if err != nil {
+		return State{}, fmt.Errorf("failed to parse header: %w", err)
+	}
+
+	rows, err := s.db.Query(`SELECT seq, entry FROM entries WHERE session_id = ? ORDER BY seq`, sessionID)
+	if err != nil {
+		return State{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	state := State{Header: header, StateFile: s.path, storedVersion: version}
+	for rows.Next() {
+		var seq int
+		var entryJson string
+		if err := rows.Scan(&seq, &entryJson); err != nil {
+			return State{}, err
+		}
+		entry, err := decodeEntry([]byte(entryJson), version)
+		if err != nil {
+			return State{}, fmt.Errorf("failed to parse history entry %d: %w", seq, err)
+		}
+		state.History = append(state.History, entry)
+	}
+	return state, rows.Err()
+}
+
+func (s *sqliteStore) Save(state State, unchanged int) (string, error) {
+	headerBytes, err := json.Marshal(state.Header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal session header: %w", err)
+	}
+
+	err = s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO sessions (id, created_at, updated_at, header) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET created_at = excluded.created_at, updated_at = excluded.updated_at, header = excluded.header`,
+			state.SessionID, state.CreatedAt.UnixNano(), state.UpdatedAt.UnixNano(), string(headerBytes))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM entries WHERE session_id = ? AND seq >= ?`, state.SessionID, unchanged)
+		if err != nil {
+			return err
+		}
+		for i := unchanged; i < len(state.History); i++ {
+			entry := state.History[i]
+			entryBytes, err := json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("failed to marshal history entry: %w", err)
+			}
+			_, err = tx.Exec(`INSERT INTO entries (session_id, seq, role, content, entry) VALUES (?, ?, ?, ?, ?)`,
+				state.SessionID, i, string(entry.Message.SourceType), entry.Message.Content, string(entryBytes))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write session %s: %w", state.SessionID, err)
+	}
+	return s.path, nil
+}
+
+func (s *sqliteStore) Delete(sessionID string) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"entries", "archives"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE session_id = ?`, sessionID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(Dir() + "/" + sessionID)
+}
+
+// Copy copies the current state, without archives, as stored in whatever schema version
+func (s *sqliteStore) Copy(sessionID string, newSessionID string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if err := copyHeaderRow(tx, sessionID, newSessionID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO entries (session_id, seq, role, content, entry)
+			SELECT ?, seq, role, content, entry FROM entries WHERE session_id = ?`, newSessionID, sessionID)
+		return err
+	})
+}
+
+func (s *sqliteStore) Rename(sessionID string, newSessionID string) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := copyHeaderRow(tx, sessionID, newSessionID); err != nil {
+			return err
+		}
+		for _, table := range []string{"entries", "archives"} {
+			_, err := tx.Exec(`UPDATE `+table+` SET session_id = ? WHERE session_id = ?`, newSessionID, sessionID)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// wire logs and caches
+	if exists, _ := util.FileExists(Dir() + "/" + sessionID); exists {
+		return moveDir(Dir()+"/"+sessionID, Dir()+"/"+newSessionID)
+	}
+	return nil
+}
+
+func copyHeaderRow(tx *sql.Tx, sessionID string, newSessionID string) error {
+	var createdAt, updatedAt int64
+	var headerJson string
+	err := tx.QueryRow(`SELECT created_at, updated_at, header FROM sessions WHERE id = ?`, sessionID).Scan(&createdAt, &updatedAt, &headerJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	if err != nil {
+		return err
+	}
+	headerBytes, err := renameHeader([]byte(headerJson), newSessionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO sessions (id, created_at, updated_at, header) VALUES (?, ?, ?, ?)`,
+		newSessionID, createdAt, updatedAt, string(headerBytes))
+	if err != nil {
+		return fmt.Errorf("session already exists: %s: %w", newSessionID, err)
+	}
+	return nil
+}
+
+// Search matches text with LIKE, which is case insensitive for ASCII letters only
+func (s *sqliteStore) Search(query Query) ([]Header, error) {
+	sqlQuery := `SELECT header FROM sessions s WHERE 1 = 1`
+	var args []any
+	if !query.Since.IsZero() {
+		sqlQuery += ` AND s.updated_at >= ?`
+		args = append(args, query.Since.UnixNano())
+	}
+	if !query.Until.IsZero() {
+		sqlQuery += ` AND s.created_at < ?`
+		args = append(args, query.Until.UnixNano())
+	}
+	if query.Text != "" {
+		sqlQuery += ` AND EXISTS (SELECT 1 FROM entries e WHERE e.session_id = s.id AND e.content LIKE ? ESCAPE '\')`
+		args = append(args, "%"+escapeLike(query.Text)+"%")
+	}
+
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []Header
+	for rows.Next() {
+		var headerJson string
+		if err := rows.Scan(&headerJson); err != nil {
+			return nil, err
+		}
+		header, _, err := decodeHeader([]byte(headerJson))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, header)
+	}
+	return result, rows.Err()
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func (s *sqliteStore) ListArchives(sessionID string) ([]int, error) {
+	rows, err := s.db.Query(`SELECT n FROM archives WHERE session_id = ? ORDER BY n`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqliteStore) LoadArchive(sessionID string, n int) (State, error) {
+	var stateJson string
+	err := s.db.QueryRow(`SELECT state FROM archives WHERE session_id = ? AND n = ?`, sessionID, n).Scan(&stateJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, fs.ErrNotExist
+	}
+	if err != nil {
+		return State{}, err
+	}
+	state, _, err := decodeState([]byte(stateJson))
+	return state, err
+}
+
+func (s *sqliteStore) SaveArchive(sessionID string, n int, state State) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session state: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO archives (session_id, n, state) VALUES (?, ?, ?)`, sessionID, n, string(stateBytes))
+	return err
+}
+
+func (s *sqliteStore) DeleteArchive(sessionID string, n int) error {
+	_, err := s.db.Exec(`DELETE FROM archives WHERE session_id = ? AND n = ?`, sessionID, n)
+	return err
+}
+
+func (s *sqliteStore) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"github.com/gigurra/ai/domain"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func withBackend(t *testing.T, backend string) {
+	t.Setenv("HOME", t.TempDir())
+	if backend == BackendSQLite {
+		store := openStore(BackendSQLite)
+		t.Cleanup(func() { closeStore(store) })
+	}
+	if name := ActiveStore().Name(); name != backend {
+		t.Fatalf("expected the %s backend to be active, got %s", backend, name)
+	}
+}
+
+func TestStores(t *testing.T) {
+	for _, backend := range []string{BackendFS, BackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			withBackend(t, backend)
+
+			state := LoadSession("one")
+			state.AddMessage(domain.Message{SourceType: domain.User, Content: "Hello World"})
+			state = StoreSession(state)
+			state.AddAnswer(domain.Message{SourceType: domain.Assistant, Content: "hi"}, domain.StopReasonEndTurn)
+			StoreSession(state)
+
+			loaded := LoadSession("one")
+			if len(loaded.History) != 2 || loaded.History[1].Message.Content != "hi" {
+				t.Fatalf("unexpected history after append: %+v", loaded.History)
+			}
+
+			CopySession("one", "two")
+			RenameSession("two", "three")
+			ids := sessionIDs(ListSessions())
+			if !slices.Equal(ids, []string{"three", "one"}) && !slices.Equal(ids, []string{"one", "three"}) {
+				t.Errorf("expected sessions one and three, got %v", ids)
+			}
+			if len(LoadSession("three").History) != 2 {
+				t.Errorf("expected the renamed copy to keep its history")
+			}
+
+			if found := sessionIDs(SearchSessions(Query{Text: "hello world"})); len(found) != 2 {
+				t.Errorf("expected both sessions to match the text, got %v", found)
+			}
+			if found := SearchSessions(Query{Text: "missing"}); len(found) != 0 {
+				t.Errorf("expected no session to match, got %v", found)
+			}
+			if found := SearchSessions(Query{Since: time.Now().Add(time.Hour)}); len(found) != 0 {
+				t.Errorf("expected no session updated in the future, got %v", found)
+			}
+
+			n := ArchiveState("one")
+			if archives := ListArchives("one"); !slices.Equal(archives, []int{n}) {
+				t.Errorf("expected archive %d, got %v", n, archives)
+			}
+			RestoreArchive("one", n)
+			if archives := ListArchives("one"); len(archives) != 0 {
+				t.Errorf("expected the restored archive to be removed, got %v", archives)
+			}
+
+			DeleteSession("three", true)
+			if StoredSessionExists("three") {
+				t.Errorf("expected session three to be deleted")
+			}
+		})
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	withBackend(t, BackendFS)
+
+	state := LoadSession("moved")
+	state.AddMessage(domain.Message{SourceType: domain.User, Content: "q"})
+	StoreSession(state)
+	ArchiveState("moved")
+
+	if n := MigrateStorage(BackendSQLite); n != 1 {
+		t.Fatalf("expected 1 session to be moved, got %d", n)
+	}
+	t.Cleanup(func() { closeStore(ActiveStore()) })
+	if ActiveStore().Name() != BackendSQLite {
+		t.Fatalf("expected sqlite to be active after the migration")
+	}
+	if exists, _ := (fsStore{}).Exists("moved"); exists {
+		t.Errorf("expected the session files to be removed from the session dir")
+	}
+	if loaded := LoadSession("moved"); len(loaded.History) != 1 || len(ListArchives("moved")) != 1 {
+		t.Errorf("expected the session and its archive in sqlite, got %+v", loaded)
+	}
+
+	MigrateStorage(BackendFS)
+	if ActiveStore().Name() != BackendFS || len(LoadSession("moved").History) != 1 {
+		t.Errorf("expected the session back in the session dir")
+	}
+}
+
+func sessionIDs(headers []Header) []string {
+	var ids []string
+	for _, header := range headers {
+		ids = append(ids, header.SessionID)
+	}
+	return ids
+}
+
+func TestDirWithoutHeaderIsNoSession(t *testing.T) {
+	for _, backend := range []string{BackendFS, BackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			withBackend(t, backend)
+			// e.g. wire logs of a first request that failed
+			if err := os.MkdirAll(Dir()+"/logs-only/wire", 0755); err != nil {
+				t.Fatal(err)
+			}
+			_ = os.WriteFile(Dir()+"/logs-only/wire/1.json", []byte("{}"), 0644)
+			if StoredSessionExists("logs-only") {
+				t.Fatalf("expected a dir without a header not to be a session")
+			}
+
+			state := LoadSession("real")
+			state.AddMessage(domain.Message{SourceType: domain.User, Content: "q"})
+			StoreSession(state)
+			RenameSession("real", "logs-only")
+			if len(LoadSession("logs-only").History) != 1 || StoredSessionExists("real") {
+				t.Errorf("expected the session to be renamed into the dir")
+			}
+			if _, err := os.Stat(Dir() + "/logs-only/wire/1.json"); err != nil {
+				t.Errorf("expected the wire log to be kept: %v", err)
+			}
+		})
+	}
+}
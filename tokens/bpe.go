@@ -0,0 +1,106 @@
+package tokens
+
+import (
+	"fmt"
+	"github.com/gigurra/ai/domain"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Counter counts the tokens of text for a specific model
+type Counter interface {
+	Count(text string) int
+	// Name is the tokenizer used, e.g. o200k_base, or "estimate" for the heuristic
+	Name() string
+}
+
+type heuristicCounter struct{}
+
+func (heuristicCounter) Count(text string) int {
+	return Estimate(text)
+}
+
+func (heuristicCounter) Name() string {
+	return "estimate"
+}
+
+type bpeCounter struct {
+	encoding string
+	codec    *tiktoken.Tiktoken
+}
+
+func (c bpeCounter) Count(text string) int {
+	return len(c.codec.EncodeOrdinary(text))
+}
+
+func (c bpeCounter) Name() string {
+	return c.encoding
+}
+
+// openAIEncodings maps OpenAI model id prefixes to their BPE encodings. The longest matching prefix wins.
+var openAIEncodings = map[string]string{
+	"gpt-3.5":        tiktoken.MODEL_CL100K_BASE,
+	"gpt-4":          tiktoken.MODEL_CL100K_BASE,
+	"gpt-4o":         tiktoken.MODEL_O200K_BASE,
+	"gpt-4.1":        tiktoken.MODEL_O200K_BASE,
+	"gpt-4.5":        tiktoken.MODEL_O200K_BASE,
+	"gpt-5":          tiktoken.MODEL_O200K_BASE,
+	"o1":             tiktoken.MODEL_O200K_BASE,
+	"o3":             tiktoken.MODEL_O200K_BASE,
+	"o4":             tiktoken.MODEL_O200K_BASE,
+	"text-embedding": tiktoken.MODEL_CL100K_BASE,
+}
+
+var loadBpeLoader = sync.OnceFunc(func() {
+	// the BPE tables are embedded in the binary, so nothing is downloaded
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+})
+
+var codecs = sync.Map{}
+
+// ForModel returns an exact BPE counter for OpenAI models, and the heuristic estimate for
+// everything else. For azure-openai, the deployment name must start with the model name
+// for the BPE tables to be used.
+func ForModel(provider string, model string) Counter {
+	if provider != "openai" && provider != "azure-openai" {
+		return heuristicCounter{}
+	}
+
+	model = strings.ToLower(strings.TrimSpace(model))
+	encoding := ""
+	bestPrefix := ""
+	for prefix, candidate := range openAIEncodings {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+			encoding = candidate
+		}
+	}
+	if encoding == "" {
+		return heuristicCounter{}
+	}
+
+	if codec, ok := codecs.Load(encoding); ok {
+		return bpeCounter{encoding: encoding, codec: codec.(*tiktoken.Tiktoken)}
+	}
+
+	loadBpeLoader()
+	codec, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to load %s BPE tables, estimating tokens instead: %v", encoding, err))
+		return heuristicCounter{}
+	}
+	codecs.Store(encoding, codec)
+	return bpeCounter{encoding: encoding, codec: codec}
+}
+
+// CountMessages counts the prompt tokens of a conversation
+func CountMessages(counter Counter, messages []domain.Message) int {
+	total := 0
+	for _, message := range messages {
+		total += counter.Count(message.Content) + perMessageOverhead
+	}
+	return total
+}
@@ -0,0 +1,35 @@
+package tokens
+
+import (
+	"github.com/gigurra/ai/domain"
+	"unicode"
+	"unicode/utf8"
+)
+
+// perMessageOverhead approximates the role and framing tokens providers add to every message
+const perMessageOverhead = 4
+
+// Estimate approximates the number of tokens in text without calling a provider. ASCII text
+// and code average about 4 characters per token, while other scripts are closer to one token
+// per character.
+func Estimate(text string) int {
+	ascii := 0
+	other := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else if !unicode.IsSpace(r) {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// EstimateMessages approximates the number of prompt tokens of a conversation
+func EstimateMessages(messages []domain.Message) int {
+	total := 0
+	for _, message := range messages {
+		total += Estimate(message.Content) + perMessageOverhead
+	}
+	return total
+}
@@ -0,0 +1,34 @@
+package tokens
+
+import (
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	if got := Estimate(""); got != 0 {
+		t.Errorf("Estimate(\"\") = %d; want 0", got)
+	}
+	if got := Estimate("abcdefgh"); got != 2 {
+		t.Errorf("Estimate(8 ascii chars) = %d; want 2", got)
+	}
+	if got := Estimate("日本語"); got != 3 {
+		t.Errorf("Estimate(3 CJK chars) = %d; want 3", got)
+	}
+}
+
+func TestForModel(t *testing.T) {
+	counter := ForModel("openai", "gpt-4o-mini")
+	if counter.Name() != "o200k_base" {
+		t.Fatalf("expected o200k_base for gpt-4o-mini, got %s", counter.Name())
+	}
+	if got := counter.Count("hello world"); got != 2 {
+		t.Errorf("Count(\"hello world\") = %d; want 2", got)
+	}
+
+	if name := ForModel("openai", "gpt-4-turbo").Name(); name != "cl100k_base" {
+		t.Errorf("expected cl100k_base for gpt-4-turbo, got %s", name)
+	}
+	if name := ForModel("anthropic", "claude-sonnet-4").Name(); name != "estimate" {
+		t.Errorf("expected the heuristic for anthropic, got %s", name)
+	}
+}
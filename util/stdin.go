@@ -28,3 +28,22 @@ func ReadAllStdIn() (string, error) {
 	}
 	return stdInContents, nil
 }
+
+// ReadTerminalLine reads a line from the controlling terminal, even when stdin is piped
+func ReadTerminalLine() (string, error) {
+	device := "/dev/tty"
+	if IsWindows() {
+		device = "CONIN$"
+	}
+	tty, err := os.Open(device)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to read from: %v", err)
+	}
+	defer func() { _ = tty.Close() }()
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read from terminal: %v", err)
+	}
+	return strings.TrimSpace(line), nil
+}
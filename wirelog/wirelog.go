@@ -0,0 +1,252 @@
+package wirelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/ai/util"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const redacted = "*****"
+
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+
+var secretQueryParams = []string{"key", "api_key", "access_token"}
+
+// Exchange is one http request and its response, as sent and received
+type Exchange struct {
+	Time       time.Time `json:"time"`
+	DurationMs int64     `json:"duration_ms"`
+	Request    Request   `json:"request"`
+	Response   *Response `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body,omitempty"`
+	Events  []Event     `json:"events,omitempty"` // instead of Body, for server-sent events
+}
+
+// Event is a server-sent event
+type Event struct {
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+var (
+	installOnce sync.Once
+	enabledDir  atomic.Pointer[string]
+)
+
+// Enable records all http calls made through the default transport and util.HttpClient,
+// one file per call, in dir. Calling it again sends the calls made after it to another dir.
+func Enable(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to create wire log dir, not logging: %v", err))
+		return
+	}
+	enabledDir.Store(&dir)
+	installOnce.Do(func() {
+		http.DefaultTransport = &transport{next: http.DefaultTransport, dir: currentDir}
+		util.HttpClient.SetTransport(&transport{next: util.HttpClient.GetClient().Transport, dir: currentDir})
+	})
+}
+
+func currentDir() string {
+	if dir := enabledDir.Load(); dir != nil {
+		return *dir
+	}
+	return ""
+}
+
+type transport struct {
+	next http.RoundTripper
+	dir  func() string // where to record a call, read when it is made. Empty = not recorded
+}
+
+var sequence atomic.Int64
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dir := t.dir()
+	if dir == "" {
+		return t.next.RoundTrip(req)
+	}
+	start := time.Now()
+	rec := &recording{
+		file: filepath.Join(dir, fmt.Sprintf("%s-%03d.json", start.Format("20060102-150405.000"), sequence.Add(1))),
+		exchange: Exchange{
+			Time: start,
+			Request: Request{
+				Method:  req.Method,
+				URL:     redactURL(req.URL),
+				Headers: redactHeaders(req.Header),
+			},
+		},
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		rec.exchange.Request.Body = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	rec.store()
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		rec.exchange.Error = err.Error()
+		rec.finish()
+		return nil, err
+	}
+
+	rec.exchange.Response = &Response{
+		Status:  res.StatusCode,
+		Headers: redactHeaders(res.Header),
+	}
+	rec.isSSE = strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream")
+	res.Body = &recordingBody{ReadCloser: res.Body, rec: rec}
+	return res, nil
+}
+
+type recording struct {
+	file     string
+	exchange Exchange
+	body     bytes.Buffer
+	isSSE    bool
+	once     sync.Once
+}
+
+func (r *recording) finish() {
+	r.once.Do(func() {
+		r.exchange.DurationMs = time.Since(r.exchange.Time).Milliseconds()
+		if r.exchange.Response != nil {
+			if r.isSSE {
+				r.exchange.Response.Events = ParseEvents(r.body.String())
+			} else {
+				r.exchange.Response.Body = r.body.String()
+			}
+		}
+		r.store()
+	})
+}
+
+func (r *recording) store() {
+	data, err := json.MarshalIndent(r.exchange, "", "  ")
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to marshal wire log: %v", err))
+		return
+	}
+	if err := util.WriteFileAtomic(r.file, data, 0600); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to write wire log: %v", err))
+	}
+}
+
+// recordingBody keeps what the caller reads, and stores the exchange once the body is read or closed
+type recordingBody struct {
+	io.ReadCloser
+	rec *recording
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.body.Write(p[:n])
+	if err != nil {
+		if err != io.EOF {
+			b.rec.exchange.Error = err.Error()
+		}
+		b.rec.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.rec.finish()
+	return b.ReadCloser.Close()
+}
+
+// ParseEvents splits a server-sent event stream into its events
+func ParseEvents(stream string) []Event {
+	var events []Event
+	current := Event{}
+	var data []string
+	flush := func() {
+		if current.Event != "" || len(data) > 0 {
+			current.Data = strings.Join(data, "\n")
+			events = append(events, current)
+		}
+		current = Event{}
+		data = nil
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(stream, "\r\n", "\n"), "\n") {
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "event:"):
+			current.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	flush()
+	return events
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	result := headers.Clone()
+	for name := range result {
+		if slices.ContainsFunc(secretHeaders, func(secret string) bool { return strings.EqualFold(secret, name) }) {
+			result[name] = []string{redacted}
+		}
+	}
+	return result
+}
+
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	query := redactedURL.Query()
+	changed := false
+	for _, param := range secretQueryParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		redactedURL.RawQuery = strings.ReplaceAll(query.Encode(), url.QueryEscape(redacted), redacted)
+	}
+	redactedURL.User = nil
+	return redactedURL.String()
+}
+
+// Files returns the wire log files in dir, oldest first
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	return files, nil
+}
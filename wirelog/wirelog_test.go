@@ -0,0 +1,81 @@
+package wirelog
+
+import (
+	"github.com/gigurra/ai/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripRecordsRedactedExchange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "event: message_start\ndata: {\"a\":1}\n\nevent: message_stop\ndata: {}\n\n")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: &transport{next: http.DefaultTransport, dir: func() string { return dir }}}
+
+	req, _ := http.NewRequest("POST", server.URL+"/v1/messages?key=secret&alt=sse", strings.NewReader(`{"q":"hi"}`))
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if !strings.Contains(string(body), "message_stop") {
+		t.Fatalf("expected the caller to get the full body, got %q", body)
+	}
+
+	files, err := Files(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one wire log file, got %v, %v", files, err)
+	}
+	exchange, err := util.ReadFileAsJson[Exchange](files[0])
+	if err != nil {
+		t.Fatalf("failed to read wire log: %v", err)
+	}
+
+	if strings.Contains(exchange.Request.URL, "secret") || exchange.Request.Headers.Get("X-Api-Key") != redacted {
+		t.Errorf("expected secrets to be redacted, got %s %v", exchange.Request.URL, exchange.Request.Headers)
+	}
+	if exchange.Request.Headers.Get("Anthropic-Version") != "2023-06-01" || exchange.Request.Body != `{"q":"hi"}` {
+		t.Errorf("expected the request to be recorded, got %+v", exchange.Request)
+	}
+	if exchange.Response == nil || len(exchange.Response.Events) != 2 || exchange.Response.Events[0].Event != "message_start" || exchange.Response.Events[0].Data != `{"a":1}` {
+		t.Errorf("expected the events to be recorded, got %+v", exchange.Response)
+	}
+}
+
+func TestEnableSendsLaterCallsToTheLatestDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	get := func() {
+		res, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.ReadAll(res.Body)
+		_ = res.Body.Close()
+	}
+
+	first, second := t.TempDir(), t.TempDir()
+	Enable(first)
+	get()
+	Enable(second)
+	get()
+
+	for _, dir := range []string{first, second} {
+		if files, err := Files(dir); err != nil || len(files) != 1 {
+			t.Errorf("expected one call recorded in %s, got %v, %v", dir, files, err)
+		}
+	}
+}